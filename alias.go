@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// aliasKeySchemes are the ways the keys of mutt aliases can be made, see
+// aliasKey.
+var aliasKeySchemes = []string{"first-last", "first", "local", "address"}
+
+// aliasKeyWord keeps the lower cased ASCII letters, digits, dots, dashes and
+// underscores of s, which are safe in an alias key.
+func aliasKeyWord(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '_' {
+			b.WriteRune(r)
+		}
+	}
+	return strings.Trim(b.String(), ".-_")
+}
+
+// aliasKey is the key of the alias of aD according to scheme:
+//
+//   - first-last: the first and last word of the name, e.g. jane-doe
+//   - first: the first word of the name
+//   - local: the local part of the address
+//   - address: the address itself
+//
+// Names are taken from NormalizedName, so that transliterate applies to keys.
+// Addresses without a usable name get the key of their local part.
+func aliasKey(aD AddressData, scheme string) string {
+	name := aD.NormalizedName
+	if name == "" {
+		name = removeDiacritics(aD.Name)
+	}
+	var words []string
+	for _, word := range strings.Fields(name) {
+		if word = aliasKeyWord(word); word != "" {
+			words = append(words, word)
+		}
+	}
+	local, _, _ := strings.Cut(aD.Address, "@")
+	key := ""
+	switch scheme {
+	case "first-last":
+		if len(words) > 0 {
+			key = words[0]
+		}
+		if len(words) > 1 {
+			key += "-" + words[len(words)-1]
+		}
+	case "first":
+		if len(words) > 0 {
+			key = words[0]
+		}
+	case "address":
+		key = aliasKeyWord(strings.ReplaceAll(aD.Address, "@", "."))
+	}
+	if key == "" {
+		key = aliasKeyWord(local)
+	}
+	if key == "" {
+		key = "alias"
+	}
+	return key
+}
+
+// rfc5322Specials are the characters which have to be in a quoted string in a
+// display name, and #, which starts a comment in muttrc.
+const rfc5322Specials = "()<>[]:;@\\,.\"#"
+
+// muttAddress formats the name and address of aD for an alias line: the name
+// is quoted if it needs to be, and the characters muttrc would interpret are
+// escaped as mutt does when it saves an alias, with # on top.
+func muttAddress(aD AddressData) string {
+	name := strings.Join(strings.Fields(aD.Name), " ")
+	address := aD.Address
+	if name != "" {
+		if strings.ContainsAny(name, rfc5322Specials) {
+			name = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+		}
+		address = name + " <" + address + ">"
+	}
+	var b strings.Builder
+	for _, r := range address {
+		if strings.ContainsRune("\\`'\"$#", r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// writeMuttAliases writes an alias line for every address in rank order.
+// Keys already taken by a better ranked address get a number appended, from
+// 2 on.
+func writeMuttAliases(w io.Writer, ranked []AddressData, scheme string) error {
+	bw := bufio.NewWriter(w)
+	taken := make(map[string]bool, len(ranked))
+	for _, aD := range ranked {
+		base := aliasKey(aD, scheme)
+		key := base
+		for i := 2; taken[key]; i++ {
+			key = base + strconv.Itoa(i)
+		}
+		taken[key] = true
+		bw.WriteString("alias " + key + " " + muttAddress(aD) + "\n")
+	}
+	return bw.Flush()
+}
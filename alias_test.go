@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAliasKey(t *testing.T) {
+	tests := []struct {
+		aD     AddressData
+		scheme string
+		want   string
+	}{
+		{AddressData{Address: "jane.doe@corp.com", Name: "Jane Ann Doe", NormalizedName: "Jane Ann Doe"}, "first-last", "jane-doe"},
+		{AddressData{Address: "jane.doe@corp.com", Name: "Jane Ann Doe", NormalizedName: "Jane Ann Doe"}, "first", "jane"},
+		{AddressData{Address: "jane.doe@corp.com", Name: "Jane Ann Doe"}, "local", "jane.doe"},
+		{AddressData{Address: "jane.doe@corp.com", Name: "Jane Ann Doe"}, "address", "jane.doe.corp.com"},
+		{AddressData{Address: "cher@music.com", Name: "Cher", NormalizedName: "Cher"}, "first-last", "cher"},
+		{AddressData{Address: "arpad@example.com", Name: "Árpád O'Brien"}, "first-last", "arpad-obrien"},
+		{AddressData{Address: "ivan@example.com", Name: "Иван Петров", NormalizedName: "Ivan Petrov"}, "first-last", "ivan-petrov"},
+		{AddressData{Address: "ivan@example.com", Name: "Иван Петров", NormalizedName: "Иван Петров"}, "first-last", "ivan"},
+		{AddressData{Address: "+++@example.com"}, "first-last", "alias"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme+" "+tt.aD.Name, func(t *testing.T) {
+			assert.Equal(t, tt.want, aliasKey(tt.aD, tt.scheme))
+		})
+	}
+}
+
+func TestMuttAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", `jane@corp.com`},
+		{"Jane  Doe", `Jane Doe <jane@corp.com>`},
+		{"Doe, Jane", `\"Doe, Jane\" <jane@corp.com>`},
+		{`Jane "JD" Doe`, `\"Jane \\\"JD\\\" Doe\" <jane@corp.com>`},
+		{"Jane O'Doe", `Jane O\'Doe <jane@corp.com>`},
+		{"Jane $HOME `id` #1", `\"Jane \$HOME \` + "`id\\`" + ` \#1\" <jane@corp.com>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, muttAddress(AddressData{Address: "jane@corp.com", Name: tt.name}))
+		})
+	}
+}
+
+func TestWriteMuttAliases(t *testing.T) {
+	ranked := []AddressData{
+		{Address: "jane@corp.com", Name: "Jane Doe", NormalizedName: "Jane Doe"},
+		{Address: "jane.doe@home.org", Name: "Jane Doe", NormalizedName: "Jane Doe"},
+		{Address: "jd@other.org", Name: "Jane Doe", NormalizedName: "Jane Doe"},
+		{Address: "jane-doe2@corp.com"},
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, writeMuttAliases(&buf, ranked, "first-last"))
+	assert.Equal(t, "alias jane-doe Jane Doe <jane@corp.com>\n"+
+		"alias jane-doe2 Jane Doe <jane.doe@home.org>\n"+
+		"alias jane-doe3 Jane Doe <jd@other.org>\n"+
+		"alias jane-doe22 jane-doe2@corp.com\n", buf.String())
+}
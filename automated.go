@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// automatedSignals are the signs of a message sent by a machine rather than a
+// person, see hasAutomatedSignal.
+var automatedSignals = []string{
+	"precedence",
+	"auto-submitted",
+	"list-unsubscribe",
+	"x-auto-response-suppress",
+	"feedback-id",
+}
+
+// automatedPolicies are what can be done with the sender of a message with an
+// automated signal, from the mildest to the strictest: nothing, setting
+// IsAutomated, also counting the message in class 0 or leaving the sender
+// out.
+var automatedPolicies = []string{"ignore", "tag", "demote", "drop"}
+
+// automatedFields are the headers holding the sender of a message, which are
+// the ones affected by the automated policies.
+var automatedFields = []string{"from", "sender", "reply-to", "resent-from", "resent-sender"}
+
+// hasAutomatedSignal checks whether h carries signal:
+//
+//   - precedence: a Precedence of bulk or junk
+//   - auto-submitted: an Auto-Submitted other than no, or auto-replied as
+//     used for the out of office replies of people
+//   - list-unsubscribe: a List-Unsubscribe without a List-Id, as mailing
+//     lists of people are recognized by their List-Id
+//   - x-auto-response-suppress, feedback-id: the header at all
+func hasAutomatedSignal(h *mail.Header, signal string) bool {
+	value := strings.ToLower(strings.TrimSpace(h.Get(signal)))
+	switch signal {
+	case "precedence":
+		return value == "bulk" || value == "junk"
+	case "auto-submitted":
+		value, _, _ = strings.Cut(value, ";")
+		value = strings.TrimSpace(value)
+		return value != "" && value != "no" && value != "auto-replied"
+	case "list-unsubscribe":
+		return value != "" && h.Get("list-id") == ""
+	}
+	return value != ""
+}
+
+// automatedPolicy returns the strictest of the policies of the signals h
+// carries, or an empty string if there are none.
+func automatedPolicy(h *mail.Header, policies map[string]string) string {
+	strictest := ""
+	for _, signal := range automatedSignals {
+		policy, ok := policies[signal]
+		if !ok || policy == "ignore" || !hasAutomatedSignal(h, signal) {
+			continue
+		}
+		if slices.Index(automatedPolicies, policy) > slices.Index(automatedPolicies, strictest) {
+			strictest = policy
+		}
+	}
+	return strictest
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutomatedPolicy(t *testing.T) {
+	header := func(fields ...string) *mail.Header {
+		h := &mail.Header{}
+		for i := 0; i < len(fields); i += 2 {
+			h.Add(fields[i], fields[i+1])
+		}
+		return h
+	}
+	policies := map[string]string{
+		"precedence":               "demote",
+		"auto-submitted":           "drop",
+		"list-unsubscribe":         "tag",
+		"x-auto-response-suppress": "ignore",
+		"feedback-id":              "tag",
+	}
+
+	tests := []struct {
+		testname string
+		header   *mail.Header
+		want     string
+	}{
+		{"No signals", header("From", "a@example.com"), ""},
+		{"Precedence bulk", header("Precedence", "bulk"), "demote"},
+		{"Precedence list", header("Precedence", "list"), ""},
+		{"Auto-Submitted", header("Auto-Submitted", "auto-generated"), "drop"},
+		{"Auto-Submitted no", header("Auto-Submitted", "no"), ""},
+		{"Out of office", header("Auto-Submitted", "auto-replied; owner-email=a@example.com"), ""},
+		{"List-Unsubscribe", header("List-Unsubscribe", "<mailto:u@example.com>"), "tag"},
+		{"Mailing list", header("List-Unsubscribe", "<mailto:u@example.com>", "List-Id", "<list.example.com>"), ""},
+		{"Ignored", header("X-Auto-Response-Suppress", "All"), ""},
+		{"Strictest wins", header("Feedback-ID", "1:2", "Precedence", "junk"), "demote"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			assert.Equal(t, tt.want, automatedPolicy(tt.header, policies))
+		})
+	}
+	assert.Equal(t, "", automatedPolicy(header("Precedence", "bulk"), nil))
+}
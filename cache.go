@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheVersion needs to be bumped whenever the layout of the cached data
+// changes, so that old caches are discarded instead of misread.
+const cacheVersion = 9
+
+// mboxTailSize is the number of bytes before the parsed offset of an mbox
+// that are checksummed to tell whether it was only appended to.
+const mboxTailSize = 4096
+
+// fileContribution holds the addresses extracted from a single file along
+// with what is needed to tell whether the file changed since.
+type fileContribution struct {
+	Size      int64
+	ModTime   int64
+	Mbox      bool
+	Offset    int64
+	TailSum   uint32
+	Addresses map[string]AddressData
+	// MinDate and MaxDate are the dates of the oldest and newest message,
+	// both are 0 if there are none.
+	MinDate int64
+	MaxDate int64
+	// Threads records the message ids and replies of the file.
+	Threads *threadIndex
+
+	// parsed and errors count the messages read during this run, failed
+	// is set if the file could not be parsed to its end.
+	parsed int
+	errors int
+	failed bool
+}
+
+// addDate widens the date range of the contribution to include date.
+func (c *fileContribution) addDate(date int64) {
+	if c.MinDate == 0 && c.MaxDate == 0 {
+		c.MinDate, c.MaxDate = date, date
+		return
+	}
+	c.MinDate = min(c.MinDate, date)
+	c.MaxDate = max(c.MaxDate, date)
+}
+
+const (
+	windowNone = iota
+	windowPart
+	windowAll
+)
+
+// inWindow tells whether none, some or all of the messages of the
+// contribution lie within the window of opts.
+func (c *fileContribution) inWindow(opts parseOptions) int {
+	if !opts.hasWindow() {
+		return windowAll
+	}
+	if c.MinDate == 0 && c.MaxDate == 0 {
+		return windowNone
+	}
+	minIn := opts.inWindow(time.Unix(c.MinDate, 0))
+	maxIn := opts.inWindow(time.Unix(c.MaxDate, 0))
+	switch {
+	case minIn && maxIn:
+		return windowAll
+	case minIn || maxIn:
+		return windowPart
+	case !opts.since.IsZero() && c.MaxDate < opts.since.Unix():
+		return windowNone
+	case !opts.until.IsZero() && c.MinDate >= opts.until.Unix():
+		return windowNone
+	}
+	// The messages reach from before since to after until.
+	return windowPart
+}
+
+// cacheKey identifies a file read as part of the source at Source. Sources
+// can overlap, in which case a file contributes differently to each of them.
+type cacheKey struct {
+	Source string
+	Path   string
+}
+
+// addressCache persists the addresses found in every scanned file, so that
+// subsequent runs only need to parse new or changed files.
+type addressCache struct {
+	Version     int
+	Fingerprint string
+	Files       map[cacheKey]*fileContribution
+	path        string
+	seen        map[cacheKey]bool
+}
+
+// cacheFingerprint identifies the settings which influence how addresses are
+// extracted from a message, a cache built with different settings is useless.
+func cacheFingerprint(opts parseOptions, sources []mailSource) string {
+	h := sha256.New()
+	fmt.Fprintln(h, cacheVersion)
+	for _, addr := range opts.useraddresses {
+		fmt.Fprintln(h, "address", addr.String())
+	}
+	for _, filt := range opts.customFilters {
+		fmt.Fprintln(h, "filter", filt.String())
+	}
+	fmt.Fprintln(h, "headers", opts.headers)
+	fmt.Fprintln(h, "canonicalize", opts.canonicalize)
+	for _, signal := range automatedSignals {
+		fmt.Fprintln(h, "automated", signal, opts.automated[signal])
+	}
+	for _, rule := range opts.classes {
+		fmt.Fprintln(h, "class", rule)
+	}
+	fmt.Fprintln(h, "half-life", opts.halfLife)
+	fmt.Fprintln(h, "date-fallback", opts.dateFallback, opts.futureDates)
+	for _, source := range sources {
+		opts := source.parseOptions(opts)
+		fmt.Fprintln(h, "source", source.Path, opts.label, opts.sent, opts.weight)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// loadCache reads the cache at path. An empty cache is returned if the file
+// does not exist, is unreadable, was built with a different fingerprint or if
+// a rebuild is requested. If path is empty caching is disabled and nil is
+// returned.
+func loadCache(path string, fingerprint string, rebuild bool) *addressCache {
+	if path == "" {
+		return nil
+	}
+	cache := &addressCache{
+		Version:     cacheVersion,
+		Fingerprint: fingerprint,
+		Files:       make(map[cacheKey]*fileContribution),
+		path:        path,
+		seen:        make(map[cacheKey]bool),
+	}
+	if rebuild {
+		return cache
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, "Couldn't open cache:", err)
+		}
+		return cache
+	}
+	defer f.Close()
+	var stored addressCache
+	if err := gob.NewDecoder(f).Decode(&stored); err != nil {
+		fmt.Fprintln(os.Stderr, "Couldn't read cache, rebuilding:", err)
+		return cache
+	}
+	if stored.Version != cacheVersion || stored.Fingerprint != fingerprint {
+		return cache
+	}
+	if stored.Files != nil {
+		cache.Files = stored.Files
+	}
+	return cache
+}
+
+// save writes the cache to disk, dropping every file which was not seen
+// during this run as those have been deleted or are not scanned anymore.
+func (c *addressCache) save() error {
+	if c == nil {
+		return nil
+	}
+	for key := range c.Files {
+		if !c.seen[key] {
+			delete(c.Files, key)
+		}
+	}
+	os.MkdirAll(filepath.Dir(c.path), os.ModePerm)
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(c); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// lookup marks path as seen in source and returns its cached contribution.
+// fresh is true if the file did not change since it was cached. Otherwise the
+// contribution is only returned if the file is an mbox which was appended to,
+// so that parsing can resume at its stored offset.
+func (c *addressCache) lookup(
+	source string,
+	path string,
+	info os.FileInfo,
+) (contribution *fileContribution, fresh bool) {
+	key := cacheKey{source, path}
+	c.seen[key] = true
+	contribution, ok := c.Files[key]
+	if !ok {
+		return nil, false
+	}
+	if contribution.Size == info.Size() && contribution.ModTime == info.ModTime().UnixNano() {
+		return contribution, true
+	}
+	if contribution.Mbox && info.Size() > contribution.Offset && contribution.Offset > 0 {
+		sum, err := mboxTailSum(path, contribution.Offset)
+		if err == nil && sum == contribution.TailSum {
+			return contribution, false
+		}
+	}
+	return nil, false
+}
+
+// store records the addresses parsed from path in source. If previous is
+// set, parsing resumed in an appended mbox and the new addresses are added to
+// the old ones. If that failed, previous is kept as it is so that the appended
+// part is parsed again on the next run. The offset of an mbox is only moved
+// forward after a successful parse.
+func (c *addressCache) store(
+	source string,
+	path string,
+	info os.FileInfo,
+	previous *fileContribution,
+	parsed *fileContribution,
+) *fileContribution {
+	key := cacheKey{source, path}
+	if parsed == nil {
+		parsed = &fileContribution{}
+	}
+	if previous != nil && parsed.failed {
+		c.seen[key] = true
+		c.Files[key] = previous
+		return previous
+	}
+	contribution := &fileContribution{
+		Size:      info.Size(),
+		ModTime:   info.ModTime().UnixNano(),
+		Mbox:      parsed.Mbox,
+		Addresses: parsed.Addresses,
+		MinDate:   parsed.MinDate,
+		MaxDate:   parsed.MaxDate,
+		Threads:   parsed.Threads,
+	}
+	if contribution.Threads == nil {
+		contribution.Threads = newThreadIndex()
+	}
+	if previous != nil {
+		contribution.Mbox = true
+		contribution.Addresses = mergeSources(previous.Addresses, parsed.Addresses)
+		threads := newThreadIndex()
+		threads.merge(previous.Threads)
+		threads.merge(contribution.Threads)
+		contribution.Threads = threads
+		if previous.MinDate != 0 || previous.MaxDate != 0 {
+			contribution.addDate(previous.MinDate)
+			contribution.addDate(previous.MaxDate)
+		}
+	}
+	if contribution.Addresses == nil {
+		contribution.Addresses = make(map[string]AddressData)
+	}
+	if contribution.Mbox && !parsed.failed {
+		contribution.Offset = info.Size()
+		contribution.TailSum, _ = mboxTailSum(path, contribution.Offset)
+	}
+	c.seen[key] = true
+	c.Files[key] = contribution
+	return contribution
+}
+
+// mboxTailSum checksums the bytes right before offset.
+func mboxTailSum(path string, offset int64) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	start := max(offset-mboxTailSize, 0)
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, io.NewSectionReader(f, start, offset-start)); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
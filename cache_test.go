@@ -0,0 +1,187 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func copyTestdata(t *testing.T, src string) string {
+	t.Helper()
+	dst := t.TempDir()
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), os.ModePerm)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), content, 0o644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dst
+}
+
+// sortedNames sorts the names of every address, as their order depends on the
+// order in which files were parsed.
+func sortedNames(data map[string]AddressData) map[string]AddressData {
+	for addr, aD := range data {
+		sort.Strings(aD.Names)
+		data[addr] = aD
+	}
+	return data
+}
+
+func TestCacheRoundtrip(t *testing.T) {
+	maildir := copyTestdata(t, "./testdata/endtoend")
+	cachepath := filepath.Join(t.TempDir(), "cache.gob")
+	useraddresses := []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}
+	fingerprint := cacheFingerprint(parseOptions{useraddresses: useraddresses}, nil)
+
+	uncached := walkSources(mailSources(maildir), parseOptions{useraddresses: useraddresses}, nil)
+
+	cache := loadCache(cachepath, fingerprint, false)
+	first := walkSources(mailSources(maildir), parseOptions{useraddresses: useraddresses}, cache)
+	assert.NoError(t, cache.save())
+	assert.Equal(t, sortedNames(uncached), sortedNames(first))
+
+	cache = loadCache(cachepath, fingerprint, false)
+	assert.NotEmpty(t, cache.Files)
+	second := walkSources(mailSources(maildir), parseOptions{useraddresses: useraddresses}, cache)
+	assert.NoError(t, cache.save())
+	assert.Equal(t, sortedNames(uncached), sortedNames(second))
+
+	cache = loadCache(cachepath, cacheFingerprint(parseOptions{}, nil), false)
+	assert.Empty(t, cache.Files)
+	cache = loadCache(cachepath, fingerprint, true)
+	assert.Empty(t, cache.Files)
+}
+
+func TestCacheRemovedFile(t *testing.T) {
+	maildir := copyTestdata(t, "./testdata/endtoend")
+	cachepath := filepath.Join(t.TempDir(), "cache.gob")
+	useraddresses := []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}
+	fingerprint := cacheFingerprint(parseOptions{useraddresses: useraddresses}, nil)
+
+	cache := loadCache(cachepath, fingerprint, false)
+	data := walkSources(mailSources(maildir), parseOptions{useraddresses: useraddresses}, cache)
+	assert.NoError(t, cache.save())
+	assert.Contains(t, data, "something@example.com")
+
+	os.Remove(filepath.Join(maildir, "not_from_me", "not_from_me_002.eml"))
+	cache = loadCache(cachepath, fingerprint, false)
+	data = walkSources(mailSources(maildir), parseOptions{useraddresses: useraddresses}, cache)
+	assert.NoError(t, cache.save())
+	assert.NotContains(t, data, "something@example.com")
+	assert.NotContains(t, cache.Files, cacheKey{maildir, filepath.Join(maildir, "not_from_me", "not_from_me_002.eml")})
+}
+
+func TestCacheAppendedMbox(t *testing.T) {
+	maildir := copyTestdata(t, "./testdata/endtoend")
+	cachepath := filepath.Join(t.TempDir(), "cache.gob")
+	fingerprint := cacheFingerprint(parseOptions{}, nil)
+	mboxpath := filepath.Join(maildir, "samplembox.mbox")
+
+	cache := loadCache(cachepath, fingerprint, false)
+	data := walkSources(mailSources(maildir), parseOptions{}, cache)
+	assert.NoError(t, cache.save())
+	count := data["git@vger.kernel.org"].ClassCount[2]
+	assert.True(t, cache.Files[cacheKey{maildir, mboxpath}].Mbox)
+
+	f, err := os.OpenFile(mboxpath, os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.NoError(t, err)
+	f.WriteString("From mboxrd@z Thu Jan  1 00:00:00 1970\n" +
+		"From: Appended <appended@example.com>\n" +
+		"To: git@vger.kernel.org\n" +
+		"Date: Sat, 18 Jan 2025 00:42:04 +0000\n" +
+		"\n" +
+		"appended message\n")
+	f.Close()
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(mboxpath, later, later)
+
+	cache = loadCache(cachepath, fingerprint, false)
+	data = walkSources(mailSources(maildir), parseOptions{}, cache)
+	assert.NoError(t, cache.save())
+	assert.Contains(t, data, "appended@example.com")
+	assert.Equal(t, count+1, data["git@vger.kernel.org"].ClassCount[2])
+}
+
+func TestCacheStoreFailedParse(t *testing.T) {
+	mboxpath := filepath.Join(t.TempDir(), "mbox")
+	os.WriteFile(mboxpath, []byte("From mboxrd@z Thu Jan  1 00:00:00 1970\n"), 0o644)
+	info, err := os.Stat(mboxpath)
+	assert.NoError(t, err)
+	cache := loadCache(filepath.Join(t.TempDir(), "cache.gob"), "", false)
+
+	previous := &fileContribution{
+		Size:      info.Size() - 1,
+		Mbox:      true,
+		Offset:    info.Size() - 1,
+		Addresses: map[string]AddressData{"old@example.com": {}},
+	}
+	failed := &fileContribution{Mbox: true, failed: true}
+	stored := cache.store("mail", mboxpath, info, previous, failed)
+	assert.Same(t, previous, stored)
+	assert.Same(t, previous, cache.Files[cacheKey{"mail", mboxpath}])
+
+	stored = cache.store("mail", mboxpath, info, nil, failed)
+	assert.Equal(t, int64(0), stored.Offset)
+	assert.Equal(t, info.Size(), stored.Size)
+}
+
+func TestCacheTimeWindow(t *testing.T) {
+	maildir := copyTestdata(t, "./testdata/endtoend")
+	cachepath := filepath.Join(t.TempDir(), "cache.gob")
+	useraddresses := []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}
+	fingerprint := cacheFingerprint(parseOptions{useraddresses: useraddresses}, nil)
+	all := parseOptions{useraddresses: useraddresses}
+	window := parseOptions{
+		useraddresses: useraddresses,
+		since:         time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		// in the middle of the messages of the mbox
+		until: time.Date(2025, 1, 18, 14, 0, 0, 0, time.UTC),
+	}
+
+	uncachedAll := walkSources(mailSources(maildir), all, nil)
+	uncached := walkSources(mailSources(maildir), window, nil)
+	assert.NotEqual(t, sortedNames(uncachedAll), sortedNames(uncached))
+
+	for i := 0; i < 2; i++ {
+		cache := loadCache(cachepath, fingerprint, false)
+		cached := walkSources(mailSources(maildir), window, cache)
+		assert.NoError(t, cache.save())
+		assert.Equal(t, sortedNames(uncached), sortedNames(cached))
+	}
+
+	cache := loadCache(cachepath, fingerprint, false)
+	cached := walkSources(mailSources(maildir), all, cache)
+	assert.Equal(t, sortedNames(uncachedAll), sortedNames(cached))
+}
+
+func TestCacheReplies(t *testing.T) {
+	maildir := copyTestdata(t, "./testdata/replies")
+	cachepath := filepath.Join(t.TempDir(), "cache.gob")
+	opts := parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}}
+	fingerprint := cacheFingerprint(opts, nil)
+
+	uncached := walkSources(mailSources(maildir), opts, nil)
+	for i := 0; i < 2; i++ {
+		cache := loadCache(cachepath, fingerprint, false)
+		cached := walkSources(mailSources(maildir), opts, cache)
+		assert.NoError(t, cache.save())
+		assert.Equal(t, sortedNames(uncached), sortedNames(cached))
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// canonicalRules are the ways addresses can be brought into a canonical form,
+// so that the messages of all forms of an address are counted together:
+//
+//   - plus: leave out +tag subaddresses, jane+lists@example.com is
+//     jane@example.com
+//   - gmail: leave out the dots Gmail ignores, and use gmail.com for
+//     googlemail.com
+//   - idn: use the Unicode form of punycode domains, so that
+//     xn--bcher-kva.example is bücher.example
+var canonicalRules = []string{"plus", "gmail", "idn"}
+
+// canonicalAddress applies rules to the lower cased address.
+func canonicalAddress(address string, rules []string) string {
+	if len(rules) == 0 {
+		return address
+	}
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return address
+	}
+	local, domain := address[:i], address[i+1:]
+	for _, rule := range rules {
+		switch rule {
+		case "plus":
+			if j := strings.Index(local, "+"); j > 0 {
+				local = local[:j]
+			}
+		case "gmail":
+			if domain == "googlemail.com" {
+				domain = "gmail.com"
+			}
+			if domain == "gmail.com" {
+				local = strings.ReplaceAll(local, ".", "")
+			}
+		case "idn":
+			if unicode, err := idna.Lookup.ToUnicode(domain); err == nil {
+				domain = strings.ToLower(unicode)
+			}
+		}
+	}
+	return local + "@" + domain
+}
+
+// addressForm counts how often a form of an address was seen, and how often
+// the user wrote to it.
+type addressForm struct {
+	Seen    int
+	Written int
+}
+
+// preferredForm is the form of the address the user wrote to most, or if the
+// user never wrote to any, the one seen most.
+func preferredForm(aD AddressData) string {
+	if len(aD.Forms) == 0 {
+		return aD.Address
+	}
+	forms := make([]string, 0, len(aD.Forms))
+	for form := range aD.Forms {
+		forms = append(forms, form)
+	}
+	sort.Slice(forms, func(i, j int) bool {
+		a, b := aD.Forms[forms[i]], aD.Forms[forms[j]]
+		if a.Written != b.Written {
+			return a.Written > b.Written
+		}
+		if a.Seen != b.Seen {
+			return a.Seen > b.Seen
+		}
+		return forms[i] < forms[j]
+	})
+	return forms[0]
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalAddress(t *testing.T) {
+	tests := []struct {
+		testname string
+		address  string
+		rules    []string
+		want     string
+	}{
+		{"No rules", "jane+work@example.com", nil, "jane+work@example.com"},
+		{"Plus", "jane+work@example.com", []string{"plus"}, "jane@example.com"},
+		{"Plus only once", "jane+a+b@example.com", []string{"plus"}, "jane@example.com"},
+		{"Leading plus kept", "+1234@sms.example.com", []string{"plus"}, "+1234@sms.example.com"},
+		{"Gmail dots", "jane.doe@gmail.com", []string{"gmail"}, "janedoe@gmail.com"},
+		{"Googlemail", "jane.doe@googlemail.com", []string{"gmail"}, "janedoe@gmail.com"},
+		{"Dots elsewhere", "jane.doe@example.com", []string{"gmail"}, "jane.doe@example.com"},
+		{"Gmail plus", "jane.doe+lists@gmail.com", []string{"plus", "gmail"}, "janedoe@gmail.com"},
+		{"IDN", "info@xn--bcher-kva.example", []string{"idn"}, "info@bücher.example"},
+		{"IDN unicode", "info@bücher.example", []string{"idn"}, "info@bücher.example"},
+		{"Not an address", "undisclosed-recipients", canonicalRules, "undisclosed-recipients"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			assert.Equal(t, tt.want, canonicalAddress(tt.address, tt.rules))
+		})
+	}
+}
+
+func TestPreferredForm(t *testing.T) {
+	aD := AddressData{
+		Address: "janedoe@gmail.com",
+		Forms: map[string]addressForm{
+			"janedoe@gmail.com":  {Seen: 5},
+			"jane.doe@gmail.com": {Seen: 2, Written: 2},
+			"jane@gmail.com":     {Seen: 2, Written: 2},
+		},
+	}
+	assert.Equal(t, "jane.doe@gmail.com", preferredForm(aD))
+	aD.Forms["jane.doe@gmail.com"] = addressForm{Seen: 1}
+	assert.Equal(t, "jane@gmail.com", preferredForm(aD))
+	assert.Equal(t, "x@example.com", preferredForm(AddressData{Address: "x@example.com"}))
+}
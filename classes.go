@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// classRule is a condition an address in a message has to meet to be put in
+// a class. Conditions which are not set always match.
+type classRule struct {
+	// Fields are the headers the address has to be in.
+	Fields []string `mapstructure:"fields"`
+	// FromMe is whether the sender of the field, see senderHeader, is one of
+	// the user's addresses.
+	FromMe *bool `mapstructure:"from-me"`
+	// ToMe is whether one of the user's addresses is among the recipients.
+	ToMe *bool `mapstructure:"to-me"`
+	// List is whether the message came through a mailing list.
+	List *bool `mapstructure:"list"`
+	// Reply is whether the message is a reply.
+	Reply *bool `mapstructure:"reply"`
+}
+
+// classRules are the rules of the classes, best class first. An address is
+// put in the class of the first rule it matches, the first of n rules being
+// class n and addresses matching none class 0.
+type classRules []classRule
+
+// defaultClassRules is the scheme where addresses the user wrote to are in
+// class 2, those the user sent copies to in class 1 and everything else in
+// class 0.
+var defaultClassRules = classRules{
+	{
+		Fields: []string{"to", "bcc", "resent-to", "resent-bcc", "mail-followup-to"},
+		FromMe: boolPtr(true),
+	},
+	{
+		Fields: []string{"cc", "resent-cc"},
+		FromMe: boolPtr(true),
+	},
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// classContext is what is known about an address in a message when its class
+// is assigned.
+type classContext struct {
+	field  string
+	sender string
+	toMe   bool
+	list   bool
+	reply  bool
+}
+
+// recipientHeaders are the headers checked for the user's addresses by the
+// to-me condition.
+var recipientHeaders = []string{"to", "cc", "bcc", "resent-to", "resent-cc", "resent-bcc"}
+
+// isUserAddress checks whether address is one of the user's addresses.
+func isUserAddress(address string, useraddresses []*regexp.Regexp) bool {
+	for _, addr := range useraddresses {
+		if addr.MatchString(address) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule classRule) matches(ctx classContext, useraddresses []*regexp.Regexp) bool {
+	if len(rule.Fields) > 0 && !slices.Contains(rule.Fields, ctx.field) {
+		return false
+	}
+	if rule.FromMe != nil && *rule.FromMe != isUserAddress(ctx.sender, useraddresses) {
+		return false
+	}
+	if rule.ToMe != nil && *rule.ToMe != ctx.toMe {
+		return false
+	}
+	if rule.List != nil && *rule.List != ctx.list {
+		return false
+	}
+	if rule.Reply != nil && *rule.Reply != ctx.reply {
+		return false
+	}
+	return true
+}
+
+func (rule classRule) String() string {
+	conditions := []string{"fields=" + strings.Join(rule.Fields, ",")}
+	for _, condition := range []struct {
+		name  string
+		value *bool
+	}{
+		{"from-me", rule.FromMe},
+		{"to-me", rule.ToMe},
+		{"list", rule.List},
+		{"reply", rule.Reply},
+	} {
+		if condition.value != nil {
+			conditions = append(conditions, fmt.Sprintf("%s=%t", condition.name, *condition.value))
+		}
+	}
+	return strings.Join(conditions, " ")
+}
+
+// count is the number of classes, including class 0.
+func (rules classRules) count() int {
+	if len(rules) == 0 {
+		return len(defaultClassRules) + 1
+	}
+	return len(rules) + 1
+}
+
+// assign returns the class of an address. Without any addresses of the user
+// every address is in the best class.
+func (rules classRules) assign(ctx classContext, useraddresses []*regexp.Regexp) int {
+	if len(rules) == 0 {
+		rules = defaultClassRules
+	}
+	if len(useraddresses) == 0 {
+		return len(rules)
+	}
+	for i, rule := range rules {
+		if rule.matches(ctx, useraddresses) {
+			return len(rules) - i
+		}
+	}
+	return 0
+}
+
+// growClasses makes room for n classes in the per-class data of aD.
+func growClasses(aD *AddressData, n int) {
+	for len(aD.ClassCount) < n {
+		aD.ClassCount = append(aD.ClassCount, 0)
+	}
+	for len(aD.ClassDate) < n {
+		aD.ClassDate = append(aD.ClassDate, 0)
+	}
+	for len(aD.ClassDecay) < n {
+		aD.ClassDecay = append(aD.ClassDecay, 0)
+	}
+}
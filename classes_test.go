@@ -0,0 +1,43 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassRules(t *testing.T) {
+	useraddresses := []*regexp.Regexp{regexp.MustCompile("me@myself.me")}
+	rules := classRules{
+		{Fields: []string{"to"}, FromMe: boolPtr(true), Reply: boolPtr(true)},
+		{Fields: []string{"to", "bcc"}, FromMe: boolPtr(true)},
+		{Fields: []string{"cc"}, FromMe: boolPtr(true)},
+		{Fields: []string{"from"}, FromMe: boolPtr(false), ToMe: boolPtr(true), List: boolPtr(false)},
+	}
+
+	tests := []struct {
+		testname string
+		ctx      classContext
+		want     int
+	}{
+		{"Replied to", classContext{field: "to", sender: "me@myself.me", reply: true}, 4},
+		{"Wrote to", classContext{field: "to", sender: "me@myself.me"}, 3},
+		{"Bcc in a reply", classContext{field: "bcc", sender: "me@myself.me", reply: true}, 3},
+		{"Cc", classContext{field: "cc", sender: "me@myself.me"}, 2},
+		{"Wrote to me", classContext{field: "from", sender: "friend@friends.com", toMe: true}, 1},
+		{"Wrote to me on a list", classContext{field: "from", sender: "friend@friends.com", toMe: true, list: true}, 0},
+		{"Wrote to someone else", classContext{field: "from", sender: "friend@friends.com"}, 0},
+		{"Not from me", classContext{field: "to", sender: "friend@friends.com", reply: true}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			assert.Equal(t, tt.want, rules.assign(tt.ctx, useraddresses))
+		})
+	}
+
+	assert.Equal(t, 5, rules.count())
+	assert.Equal(t, 4, rules.assign(classContext{field: "from"}, nil))
+	assert.Equal(t, 3, classRules(nil).count())
+	assert.Equal(t, 2, classRules(nil).assign(classContext{field: "resent-to", sender: "me@myself.me"}, useraddresses))
+}
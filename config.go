@@ -3,10 +3,11 @@ package main
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"regexp"
+	"slices"
 	"strings"
 	"text/template"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/pflag"
@@ -17,12 +18,51 @@ func loadConfig() Config {
 	pflag.String("config", "", "path to config file")
 	pflag.StringSlice("maildir", []string{}, "comma separated list of paths to maildir folders")
 	pflag.String("outputpath", "", "path to output file")
+	pflag.String("cachepath", "", "path to the cache of parsed files, set to empty to disable caching")
+	pflag.Bool("rebuild-cache", false, "ignore the cache and parse every file again")
+	pflag.Bool("watch", false, "keep running and update the output as new mail arrives")
+	pflag.Duration("watch-debounce", 0, "how long to wait for more mail before updating the output in watch mode")
+	pflag.String("format", "", "output format: template, json, ndjson, vcard, vdir or mutt-alias")
+	pflag.String("alias-key", "", "key of the aliases with the mutt-alias format: first-last, first, local or address")
 	pflag.String("template", "", "output template")
 	pflag.String("list-template", "", "list name template")
+	pflag.String("name-policy", "", "how names are chosen: frequent, recent, provenance or weighted")
+	pflag.StringSlice("name-cleanup", []string{}, "comma separated list of name cleanup steps: via, role, last-first, whitespace, address")
+	pflag.StringSlice("transliterate", []string{}, "comma separated list of scripts transliterated in NormalizedName: latin, greek, cyrillic, kana, hangul")
 	pflag.String("addr-book-cmd", "", "optional command to query addresses from your addressbook")
+	pflag.StringSlice("addr-book-vcard", []string{}, "comma separated list of vCard files or vdir directories to query addresses from")
 	pflag.Bool("addr-book-add-unmatched", false, "flag to determine if you want unmatched addressbook contacts to be added to the output")
 	pflag.StringSlice("addresses", []string{}, "comma separated list of your email addresses (regex possible)")
+	pflag.String("since", "", "only read mail sent since this date (2019-06-30) or this long ago (2y, 6m, 3w, 10d)")
+	pflag.String("until", "", "only read mail sent before this date (2019-06-30) or this long ago (2y, 6m, 3w, 10d)")
+	pflag.Bool("mtime-filter", false, "with since and no cache, skip files last modified before since, true by default")
+	pflag.StringSlice("date-fallback", []string{}, "comma separated list of where to take missing dates from: received, filename, mtime")
+	pflag.String("future-dates", "", "what to do with dates in the future: fallback, clamp or keep")
+	pflag.Bool("discover-addresses", false, "if no addresses are given, use the ones found by discover for this run")
+	pflag.StringSlice("include", []string{}, "comma separated list of patterns of the only files and folders to read")
+	pflag.StringSlice("exclude", []string{}, "comma separated list of patterns of files and folders not to read")
+	pflag.Bool("default-excludes", false, "leave out common junk folders such as Spam and Trash, true by default")
 	pflag.StringSlice("filters", []string{}, "comma separated list of regexes to filter")
+	pflag.StringSlice("headers", []string{}, "comma separated list of headers to read addresses from")
+	pflag.StringSlice("canonicalize", []string{}, "comma separated list of rules for counting forms of an address together: plus, gmail, idn")
+	pflag.StringSlice("notmuch-exclude-tags", []string{}, "comma separated list of notmuch tags of messages to leave out")
+	pflag.String("ranking", "", "ranking within a class: ordinal, weighted, frequency, recency or frecency")
+	pflag.Float64("frequency-weight", 0, "weight of the frequency rank with weighted ranking")
+	pflag.Float64("recency-weight", 0, "weight of the recency rank with weighted ranking")
+	pflag.Float64("reply-weight", 0, "weight of the reply rank with weighted ranking")
+	pflag.Duration("half-life", 0, "time after which a message counts half as much with frecency ranking")
+	pflag.Int("query-limit", 0, "maximum number of results returned by query, 0 for no limit")
+	pflag.String("query-format", "", "format of query results: aerc or mutt")
+	pflag.Bool("query-data", false, "also write the structured data searched by query next to the output")
+	pflag.String("socketpath", "", "path to the unix socket used by serve and client")
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] discover\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] query <term>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] serve\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] client <term>...\n", os.Args[0])
+		pflag.PrintDefaults()
+	}
 	pflag.Parse()
 	viper.BindPFlags(pflag.CommandLine)
 	dir, direrr := os.UserConfigDir()
@@ -38,10 +78,32 @@ func loadConfig() Config {
 		dir, _ = os.Getwd()
 	}
 	viper.SetDefault("outputpath", dir+"/maildir-rank-addr/addressbook.tsv")
+	viper.SetDefault("cachepath", dir+"/maildir-rank-addr/cache.gob")
+	viper.SetDefault("socketpath", dir+"/maildir-rank-addr/query.sock")
 	viper.SetDefault("addresses", []string{})
 	viper.SetDefault("filters", []string{})
+	viper.SetDefault("headers", addressHeaders)
+	viper.SetDefault("default-excludes", true)
+	viper.SetDefault("mtime-filter", true)
+	viper.SetDefault("date-fallback", dateFallbacks)
+	viper.SetDefault("future-dates", "fallback")
+	viper.SetDefault("notmuch-exclude-tags", []string{"spam", "deleted"})
+	viper.SetDefault("watch-debounce", 5*time.Second)
+	viper.SetDefault("ranking", "ordinal")
+	viper.SetDefault("frequency-weight", 1.0)
+	viper.SetDefault("recency-weight", 1.0)
+	viper.SetDefault("reply-weight", 0.0)
+	for _, signal := range automatedSignals {
+		viper.SetDefault("automated."+signal, "tag")
+	}
+	viper.SetDefault("half-life", 30*24*time.Hour)
+	viper.SetDefault("query-limit", 100)
+	viper.SetDefault("query-format", "aerc")
+	viper.SetDefault("format", "template")
+	viper.SetDefault("alias-key", "first-last")
 	viper.SetDefault("template", "{{.Address}}\t{{.Name}}")
 	viper.SetDefault("list-template", "{{.ListName}}")
+	viper.SetDefault("name-policy", "frequent")
 
 	configPath, err := pflag.CommandLine.GetString("config")
 	if configPath != "" && err == nil {
@@ -54,21 +116,151 @@ func loadConfig() Config {
 			panic(fmt.Errorf("fatal error config file: %w", err))
 		}
 	}
-	if len(viper.GetStringSlice("maildir")) == 0 {
-		pflag.PrintDefaults()
+	command := pflag.Arg(0)
+	switch command {
+	case "", "discover":
+		if len(viper.GetStringSlice("maildir")) == 0 {
+			pflag.Usage()
+			os.Exit(1)
+		}
+	case "serve":
+	case "query", "client":
+		if pflag.NArg() < 2 {
+			pflag.Usage()
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "unknown command:", command)
+		pflag.Usage()
 		os.Exit(1)
 	}
-	maildirInput := viper.GetStringSlice("maildir")
-	maildirs := make([]string, len(maildirInput))
-	for i, maildir := range maildirInput {
-		maildirs[i], _ = homedir.Expand(maildir)
+	ranker, err := newRanker(
+		viper.GetString("ranking"),
+		viper.GetFloat64("frequency-weight"),
+		viper.GetFloat64("recency-weight"),
+		viper.GetFloat64("reply-weight"),
+	)
+	if err != nil {
+		panic(err)
+	}
+	namePolicy := viper.GetString("name-policy")
+	if !slices.Contains(namePolicies, namePolicy) {
+		panic(fmt.Errorf("unknown name policy: %s", namePolicy))
+	}
+	nameCleanup := viper.GetStringSlice("name-cleanup")
+	for _, step := range nameCleanup {
+		if !slices.Contains(nameCleanupSteps, step) {
+			panic(fmt.Errorf("unknown name cleanup step: %s", step))
+		}
+	}
+	transliterate := viper.GetStringSlice("transliterate")
+	for _, script := range transliterate {
+		if !slices.Contains(transliterationScripts, script) {
+			panic(fmt.Errorf("unknown transliteration script: %s", script))
+		}
+	}
+	queryFormat := viper.GetString("query-format")
+	if queryFormat != "aerc" && queryFormat != "mutt" {
+		panic(fmt.Errorf("unknown query format: %s", queryFormat))
+	}
+	now := time.Now()
+	since, err := parseTimeBound(viper.GetString("since"), now)
+	if err != nil {
+		panic(fmt.Errorf("bad since: %w", err))
+	}
+	until, err := parseTimeBound(viper.GetString("until"), now)
+	if err != nil {
+		panic(fmt.Errorf("bad until: %w", err))
+	}
+	if !since.IsZero() && !until.IsZero() && !since.Before(until) {
+		panic(fmt.Errorf("since needs to be before until"))
+	}
+	dateFallback := viper.GetStringSlice("date-fallback")
+	for _, fallback := range dateFallback {
+		if !slices.Contains(dateFallbacks, fallback) {
+			panic(fmt.Errorf("unknown date fallback: %s", fallback))
+		}
+	}
+	futureDates := viper.GetString("future-dates")
+	switch futureDates {
+	case "fallback", "clamp", "keep":
+	default:
+		panic(fmt.Errorf("unknown future-dates: %s", futureDates))
+	}
+	var sources []mailSource
+	if err := viper.UnmarshalKey("maildir", &sources, viper.DecodeHook(mailSourceDecodeHook)); err != nil {
+		panic(fmt.Errorf("bad maildir: %w", err))
+	}
+	exclude := viper.GetStringSlice("exclude")
+	if viper.GetBool("default-excludes") {
+		exclude = append(slices.Clone(defaultExcludes), exclude...)
+	}
+	for i := range sources {
+		sources[i].Path, _ = homedir.Expand(sources[i].Path)
+		if err := sources[i].validate(viper.GetStringSlice("include"), exclude); err != nil {
+			panic(err)
+		}
 	}
 	outputpath, _ := homedir.Expand(viper.GetString("outputpath"))
+	format := viper.GetString("format")
+	switch format {
+	case "template", "json", "ndjson", "vcard", "mutt-alias":
+	case "vdir":
+		if !pflag.CommandLine.Changed("outputpath") && !viper.InConfig("outputpath") {
+			panic(fmt.Errorf("the vdir format needs outputpath to be set to a directory"))
+		}
+		if outputpath == "-" {
+			panic(fmt.Errorf("the vdir format needs a directory as outputpath"))
+		}
+	default:
+		panic(fmt.Errorf("unknown output format: %s", format))
+	}
+	aliasKey := viper.GetString("alias-key")
+	if !slices.Contains(aliasKeySchemes, aliasKey) {
+		panic(fmt.Errorf("unknown alias key scheme: %s", aliasKey))
+	}
+	cachepath, _ := homedir.Expand(viper.GetString("cachepath"))
+	socketpath, _ := homedir.Expand(viper.GetString("socketpath"))
 	filterInput := viper.GetStringSlice("filters")
 	customFilters := make([]*regexp.Regexp, len(filterInput))
 	for i, filter := range filterInput {
 		customFilters[i] = regexp.MustCompile(filter)
 	}
+	headers := viper.GetStringSlice("headers")
+	for i, header := range headers {
+		headers[i] = strings.ToLower(strings.TrimSpace(header))
+	}
+	if len(headers) == 0 {
+		panic(fmt.Errorf("headers can not be empty"))
+	}
+	canonicalize := viper.GetStringSlice("canonicalize")
+	for _, rule := range canonicalize {
+		if !slices.Contains(canonicalRules, rule) {
+			panic(fmt.Errorf("unknown canonicalize rule: %s", rule))
+		}
+	}
+	var classes classRules
+	if err := viper.UnmarshalKey("classes", &classes); err != nil {
+		panic(fmt.Errorf("bad classes: %w", err))
+	}
+	for _, rule := range classes {
+		for i, field := range rule.Fields {
+			rule.Fields[i] = strings.ToLower(strings.TrimSpace(field))
+		}
+	}
+	for signal := range viper.GetStringMap("automated") {
+		if !slices.Contains(automatedSignals, signal) {
+			panic(fmt.Errorf("unknown automated signal: %s", signal))
+		}
+	}
+	automated := make(map[string]string, len(automatedSignals))
+	for _, signal := range automatedSignals {
+		policy := viper.GetString("automated." + signal)
+		if !slices.Contains(automatedPolicies, policy) {
+			panic(fmt.Errorf("unknown policy for automated %s: %s", signal, policy))
+		}
+		automated[signal] = policy
+	}
 	addressesInput := viper.GetStringSlice("addresses")
 	addresses := make([]*regexp.Regexp, len(addressesInput))
 	for i, filter := range addressesInput {
@@ -76,13 +268,41 @@ func loadConfig() Config {
 	}
 	templateString := viper.GetString("template")
 	listtemplateString := viper.GetString("list-template")
-	addressbookLookupCommandString := viper.GetString("addr-book-cmd")
 	addressbookAddUnmatched := viper.GetBool("addr-book-add-unmatched")
-	var addressbookLookupCommand *exec.Cmd
-	if addressbookLookupCommandString != "" {
-		args := strings.Fields(addressbookLookupCommandString)
-		application, arguments := args[0], args[1:]
-		addressbookLookupCommand = exec.Command(application, arguments...)
+	var addressbooks []addressbookSource
+	if err := viper.UnmarshalKey("addressbooks", &addressbooks); err != nil {
+		panic(fmt.Errorf("bad addressbooks: %w", err))
+	}
+	if command := viper.GetString("addr-book-cmd"); command != "" {
+		addressbooks = append(addressbooks, addressbookSource{Type: "cmd", Command: command})
+	}
+	for _, path := range viper.GetStringSlice("addr-book-vcard") {
+		addressbooks = append(addressbooks, addressbookSource{Type: "vcard", Path: path})
+	}
+	for i := range addressbooks {
+		source := &addressbooks[i]
+		if source.Name == "" {
+			source.Name = source.Type
+		}
+		source.Path, _ = homedir.Expand(source.Path)
+		switch source.Type {
+		case "cmd":
+			if len(strings.Fields(source.Command)) == 0 {
+				panic(fmt.Errorf("addressbook %s needs a command", source.Name))
+			}
+		case "vcard", "csv":
+			if source.Path == "" {
+				panic(fmt.Errorf("addressbook %s needs a path", source.Name))
+			}
+		default:
+			panic(fmt.Errorf("unknown addressbook type: %q", source.Type))
+		}
+		if source.NameColumn == "" {
+			source.NameColumn = "name"
+		}
+		if source.EmailColumn == "" {
+			source.EmailColumn = "email"
+		}
 	}
 
 	if !strings.HasSuffix(templateString, "\n") {
@@ -97,14 +317,75 @@ func loadConfig() Config {
 		panic(fmt.Errorf("bad list template"))
 	}
 	config := Config{
-		maildirs:                 maildirs,
-		outputpath:               outputpath,
-		useraddresses:            addresses,
-		template:                 tmpl,
-		listtemplate:             listtmpl,
-		customFilters:            customFilters,
-		addressbookLookupCommand: addressbookLookupCommand,
-		addressbookAddUnmatched:  addressbookAddUnmatched,
+		sources:                 sources,
+		outputpath:              outputpath,
+		format:                  format,
+		aliasKey:                aliasKey,
+		cachepath:               cachepath,
+		rebuildCache:            viper.GetBool("rebuild-cache"),
+		watch:                   viper.GetBool("watch"),
+		watchDebounce:           viper.GetDuration("watch-debounce"),
+		command:                 command,
+		args:                    pflag.Args(),
+		queryLimit:              viper.GetInt("query-limit"),
+		queryFormat:             queryFormat,
+		queryData:               viper.GetBool("query-data"),
+		socketpath:              socketpath,
+		useraddresses:           addresses,
+		discoverAddresses:       viper.GetBool("discover-addresses"),
+		template:                tmpl,
+		listtemplate:            listtmpl,
+		namePolicy:              namePolicy,
+		nameCleanup:             nameCleanup,
+		transliterate:           transliterate,
+		customFilters:           customFilters,
+		headers:                 headers,
+		classes:                 classes,
+		automated:               automated,
+		canonicalize:            canonicalize,
+		ranker:                  ranker,
+		halfLife:                viper.GetDuration("half-life"),
+		notmuchExcludeTags:      viper.GetStringSlice("notmuch-exclude-tags"),
+		since:                   since,
+		until:                   until,
+		mtimeFilter:             viper.GetBool("mtime-filter"),
+		dateFallback:            dateFallback,
+		futureDates:             futureDates,
+		addressbooks:            addressbooks,
+		addressbookAddUnmatched: addressbookAddUnmatched,
 	}
 	return config
 }
+
+// parseOptions returns the settings needed for extracting addresses.
+func (config Config) parseOptions() parseOptions {
+	return parseOptions{
+		useraddresses: config.useraddresses,
+		customFilters: config.customFilters,
+		headers:       config.headers,
+		classes:       config.classes,
+		automated:     config.automated,
+		canonicalize:  config.canonicalize,
+		halfLife:      config.halfLife,
+		excludeTags:   config.notmuchExcludeTags,
+		since:         config.since,
+		until:         config.until,
+		mtimeFilter:   config.mtimeFilter,
+		dateFallback:  config.dateFallback,
+		futureDates:   config.futureDates,
+	}
+}
+
+// rankingOptions returns the settings needed for ranking addresses.
+func (config Config) rankingOptions() rankingOptions {
+	// With until set, the addressbook is ranked as it would have been
+	// then.
+	return rankingOptions{
+		ranker:        config.ranker,
+		halfLife:      config.halfLife,
+		now:           config.until,
+		namePolicy:    config.namePolicy,
+		nameCleanup:   config.nameCleanup,
+		transliterate: config.transliterate,
+	}
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"os/exec"
 	"regexp"
 	"text/template"
+	"time"
 )
 
 type AddressData struct {
@@ -13,21 +13,66 @@ type AddressData struct {
 	FrequencyRank  int
 	RecencyRank    int
 	TotalRank      int
-	ClassCount     [3]int
-	ClassDate      [3]int64
+	FrecencyScore  float64
+	ClassCount     []int
+	ClassDate      []int64
 	Name           string
+	NameSource     string
 	NormalizedName string
 	ListName       string
 	ListId         string
+	Sources        []string
+	ReplyCount     int
+	ReplyDate      int64
+	ReplyRank      int
+	IsAutomated    bool
+
+	// Forms counts the forms of the address which have the same canonical
+	// form, see canonicalAddress.
+	Forms map[string]addressForm `json:"-"`
+	// NameUses records who used each of the names and when, see
+	// selectName.
+	NameUses map[string]nameUse `json:"-"`
+	// ClassDecay holds the decayed sums needed for FrecencyScore, see
+	// addDecay.
+	ClassDecay []float64 `json:"-"`
 }
 
 type Config struct {
-	maildirs                 []string
-	outputpath               string
-	useraddresses            []*regexp.Regexp
-	template                 *template.Template
-	listtemplate             *template.Template
-	customFilters            []*regexp.Regexp
-	addressbookLookupCommand *exec.Cmd
-	addressbookAddUnmatched  bool
+	sources                 []mailSource
+	outputpath              string
+	format                  string
+	aliasKey                string
+	cachepath               string
+	rebuildCache            bool
+	watch                   bool
+	watchDebounce           time.Duration
+	command                 string
+	args                    []string
+	queryLimit              int
+	queryFormat             string
+	queryData               bool
+	socketpath              string
+	useraddresses           []*regexp.Regexp
+	discoverAddresses       bool
+	template                *template.Template
+	listtemplate            *template.Template
+	namePolicy              string
+	nameCleanup             []string
+	transliterate           []string
+	customFilters           []*regexp.Regexp
+	headers                 []string
+	classes                 classRules
+	automated               map[string]string
+	canonicalize            []string
+	ranker                  Ranker
+	halfLife                time.Duration
+	notmuchExcludeTags      []string
+	since                   time.Time
+	until                   time.Time
+	mtimeFilter             bool
+	dateFallback            []string
+	futureDates             string
+	addressbooks            []addressbookSource
+	addressbookAddUnmatched bool
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// sentFolderPattern matches the names mail clients and providers give to the
+// folder of sent mail, such as Sent, Sent Items or [Gmail]/Sent Mail.
+var sentFolderPattern = regexp.MustCompile(`(?i)^sent( items| mail| messages)?$`)
+
+// discoveredAddress is an address which looks like one of the user's own.
+// Sent counts the messages in sent folders it was the sender of, Delivered
+// the messages it was the Delivered-To or X-Original-To address of.
+type discoveredAddress struct {
+	Address   string
+	Sent      int
+	Delivered int
+}
+
+// isSentFolder checks whether any folder on the way from root to path, or
+// root itself, is a sent folder. Maildir++ folders like .Sent or INBOX.Sent
+// are recognized by their last component.
+func isSentFolder(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	parts := append(strings.Split(filepath.ToSlash(rel), "/"), filepath.Base(root))
+	for _, part := range parts {
+		components := strings.Split(part, ".")
+		if sentFolderPattern.MatchString(strings.TrimSpace(components[len(components)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// discoverAddresses looks for the user's own addresses: the senders of mail
+// in sent folders (or sources marked as sent) and the addresses mail was
+// delivered to. Notmuch sources are not inspected. The result is ordered by
+// the number of messages.
+func discoverAddresses(sources []mailSource) []discoveredAddress {
+	found := make(map[string]*discoveredAddress)
+	count := func(address string) *discoveredAddress {
+		address = strings.ToLower(address)
+		if _, ok := found[address]; !ok {
+			found[address] = &discoveredAddress{Address: address}
+		}
+		return found[address]
+	}
+
+	for _, source := range sources {
+		if strings.HasPrefix(source.Path, notmuchPrefix) {
+			continue
+		}
+		messageFiles := make(chan messageFile, 4096)
+		envelopechan := make(chan envelope)
+		var wg sync.WaitGroup
+		for i := 0; i < 2*runtime.NumCPU(); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				messageParser(messageFiles, envelopechan)
+			}()
+		}
+		go func() {
+			walkMessageFiles(source, func(path string, info os.FileInfo) {
+				messageFiles <- messageFile{path: path, size: info.Size()}
+			})
+			close(messageFiles)
+			wg.Wait()
+			close(envelopechan)
+		}()
+
+		for envelope := range envelopechan {
+			if envelope.err != nil {
+				continue
+			}
+			if source.Sent || isSentFolder(source.Path, envelope.path) {
+				if from, err := envelope.header.AddressList("from"); err == nil && len(from) > 0 {
+					count(from[0].Address).Sent++
+				}
+			}
+			delivered := make(map[string]bool)
+			for _, field := range []string{"delivered-to", "x-original-to"} {
+				for _, value := range envelope.header.Values(field) {
+					address := strings.Trim(strings.TrimSpace(value), "<>")
+					if address != "" && !filterAddress(address, nil) {
+						delivered[strings.ToLower(address)] = true
+					}
+				}
+			}
+			for address := range delivered {
+				count(address).Delivered++
+			}
+		}
+	}
+
+	result := make([]discoveredAddress, 0, len(found))
+	for _, address := range found {
+		result = append(result, *address)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Sent+a.Delivered != b.Sent+b.Delivered {
+			return a.Sent+a.Delivered > b.Sent+b.Delivered
+		}
+		return a.Address < b.Address
+	})
+	return result
+}
+
+// discoveredPattern is the pattern matching exactly address, as used in the
+// addresses option.
+func discoveredPattern(address string) string {
+	return "^" + regexp.QuoteMeta(address) + "$"
+}
+
+// discoveredRegexps turns the discovered addresses into the form of the
+// addresses option.
+func discoveredRegexps(found []discoveredAddress) []*regexp.Regexp {
+	addresses := make([]*regexp.Regexp, len(found))
+	for i, address := range found {
+		addresses[i] = regexp.MustCompile(discoveredPattern(address.Address))
+	}
+	return addresses
+}
+
+// printDiscoveredAddresses writes the discovered addresses with their counts
+// followed by a config snippet using them.
+func printDiscoveredAddresses(w io.Writer, found []discoveredAddress) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "sent\tdelivered\t")
+	for _, address := range found {
+		fmt.Fprintf(tw, "%d\t%d\t  %s\n", address.Sent, address.Delivered, address.Address)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Suggested configuration:")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "addresses = [")
+	for _, address := range found {
+		fmt.Fprintf(w, "    '%s',\n", discoveredPattern(address.Address))
+	}
+	fmt.Fprintln(w, "]")
+}
+
+// runDiscover prints the addresses which look like the user's own.
+func runDiscover(config Config) error {
+	found := discoverAddresses(config.sources)
+	if len(found) == 0 {
+		return fmt.Errorf("no sent folders or Delivered-To headers found")
+	}
+	printDiscoveredAddresses(os.Stdout, found)
+	return nil
+}
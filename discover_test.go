@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSentFolder(t *testing.T) {
+	tests := []struct {
+		root string
+		path string
+		want bool
+	}{
+		{"mail", "mail/Sent/cur/1", true},
+		{"mail", "mail/sent items/cur/1", true},
+		{"mail", "mail/[Gmail]/Sent Mail/cur/1", true},
+		{"mail", "mail/.INBOX.Sent/cur/1", true},
+		{"mail", "mail/Sent.mbox", false},
+		{"mail", "mail/Sent", true},
+		{"mail/Sent", "mail/Sent/cur/1", true},
+		{"mail", "mail/INBOX/cur/1", false},
+		{"mail", "mail/Consent/cur/1", false},
+		{"sent/mail", "sent/mail/INBOX/cur/1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, isSentFolder(tt.root, tt.path))
+		})
+	}
+}
+
+func TestDiscoverAddresses(t *testing.T) {
+	found := discoverAddresses(mailSources(filepath.Join("testdata", "discover"), "notmuch:"))
+	assert.Equal(t, []discoveredAddress{
+		{"me@myself.me", 2, 2},
+		{"me+lists@myself.me", 0, 1},
+		{"me@work.example", 1, 0},
+	}, found)
+
+	addresses := discoveredRegexps(found)
+	assert.True(t, addresses[1].MatchString("me+lists@myself.me"))
+	assert.False(t, addresses[1].MatchString("mee+lists@myself.me"))
+
+	var buf bytes.Buffer
+	printDiscoveredAddresses(&buf, found)
+	assert.Contains(t, buf.String(), "   2          2  me@myself.me\n")
+	assert.Contains(t, buf.String(), "addresses = [\n    '^me@myself\\.me$',\n    '^me\\+lists@myself\\.me$',\n")
+}
@@ -1,20 +1,35 @@
 package main
 
 import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// mailSources turns paths into sources without any options.
+func mailSources(paths ...string) []mailSource {
+	sources := make([]mailSource, len(paths))
+	for i, path := range paths {
+		sources[i] = mailSource{Path: path}
+	}
+	return sources
+}
+
 func TestE2EAddressbookOverride(t *testing.T) {
-	addressbook := map[string]string{
-		"foo@bar.com":           "override FOO",
-		"something@example.com": "override EXAMPLE",
+	addressbook := map[string]addressbookEntry{
+		"foo@bar.com":           {"override FOO", "cmd"},
+		"something@example.com": {"override EXAMPLE", "cmd"},
 	}
-	data := walkSources([]string{"./testdata/endtoend"}, nil, nil)
-	classeddata := calculateRanks(data, addressbook, nil)
+	data := walkSources(mailSources("./testdata/endtoend"), parseOptions{}, nil)
+	classeddata := calculateRanks(data, addressbook, nil, rankingOptions{})
 
 	tests := []struct {
 		testname string
@@ -37,8 +52,8 @@ func TestE2EAddressbookOverride(t *testing.T) {
 }
 
 func TestE2ENormalization(t *testing.T) {
-	data := walkSources([]string{"./testdata/endtoend"}, nil, nil)
-	classeddata := calculateRanks(data, nil, nil)
+	data := walkSources(mailSources("./testdata/endtoend"), parseOptions{}, nil)
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
 
 	tests := []struct {
 		testname string
@@ -61,11 +76,11 @@ func TestE2ENormalization(t *testing.T) {
 
 func TestE2EClass(t *testing.T) {
 	data := walkSources(
-		[]string{"./testdata/endtoend"},
-		[]*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+		mailSources("./testdata/endtoend"),
+		parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}},
 		nil,
 	)
-	classeddata := calculateRanks(data, nil, nil)
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
 
 	tests := []struct {
 		testname string
@@ -89,11 +104,11 @@ func TestE2EClass(t *testing.T) {
 
 func TestE2EClassMultisource(t *testing.T) {
 	data := walkSources(
-		[]string{"./testdata/endtoend/from_me", "./testdata/endtoend/not_from_me"},
-		[]*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+		mailSources("./testdata/endtoend/from_me", "./testdata/endtoend/not_from_me"),
+		parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}},
 		nil,
 	)
-	classeddata := calculateRanks(data, nil, nil)
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
 
 	tests := []struct {
 		testname string
@@ -117,11 +132,11 @@ func TestE2EClassMultisource(t *testing.T) {
 
 func TestE2ERankingRecency(t *testing.T) {
 	data := walkSources(
-		[]string{"./testdata/endtoend"},
-		[]*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+		mailSources("./testdata/endtoend"),
+		parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}},
 		nil,
 	)
-	classeddata := calculateRanks(data, nil, nil)
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
 
 	tests := []struct {
 		testname string
@@ -144,11 +159,11 @@ func TestE2ERankingRecency(t *testing.T) {
 
 func TestE2ERankingRecencyMultisource(t *testing.T) {
 	data := walkSources(
-		[]string{"./testdata/endtoend/from_me", "./testdata/endtoend/not_from_me"},
-		[]*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+		mailSources("./testdata/endtoend/from_me", "./testdata/endtoend/not_from_me"),
+		parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}},
 		nil,
 	)
-	classeddata := calculateRanks(data, nil, nil)
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
 
 	tests := []struct {
 		testname string
@@ -171,11 +186,11 @@ func TestE2ERankingRecencyMultisource(t *testing.T) {
 
 func TestE2ERankingFrequency(t *testing.T) {
 	data := walkSources(
-		[]string{"./testdata/endtoend"},
-		[]*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+		mailSources("./testdata/endtoend"),
+		parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}},
 		nil,
 	)
-	classeddata := calculateRanks(data, nil, nil)
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
 
 	tests := []struct {
 		testname string
@@ -198,11 +213,11 @@ func TestE2ERankingFrequency(t *testing.T) {
 
 func TestE2ERankingFrequencyMultisource(t *testing.T) {
 	data := walkSources(
-		[]string{"./testdata/endtoend/from_me", "./testdata/endtoend/not_from_me"},
-		[]*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+		mailSources("./testdata/endtoend/from_me", "./testdata/endtoend/not_from_me"),
+		parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}},
 		nil,
 	)
-	classeddata := calculateRanks(data, nil, nil)
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
 
 	tests := []struct {
 		testname string
@@ -225,12 +240,12 @@ func TestE2ERankingFrequencyMultisource(t *testing.T) {
 
 func TestE2EListTemplate(t *testing.T) {
 	data := walkSources(
-		[]string{"./testdata/endtoend"},
-		[]*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+		mailSources("./testdata/endtoend"),
+		parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}},
 		nil,
 	)
 	listtmpl, _ := template.New("listtemplate").Parse("{{.ListName}}")
-	classeddata := calculateRanks(data, nil, listtmpl)
+	classeddata := calculateRanks(data, nil, listtmpl, rankingOptions{})
 
 	tests := []struct {
 		testname string
@@ -256,12 +271,12 @@ func TestE2EListTemplate(t *testing.T) {
 
 func TestE2EListTemplateDisable(t *testing.T) {
 	data := walkSources(
-		[]string{"./testdata/endtoend"},
-		[]*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+		mailSources("./testdata/endtoend"),
+		parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}},
 		nil,
 	)
 	listtmpl, _ := template.New("listtemplate").Parse("DISABLELIST")
-	classeddata := calculateRanks(data, nil, listtmpl)
+	classeddata := calculateRanks(data, nil, listtmpl, rankingOptions{})
 
 	tests := []struct {
 		testname string
@@ -285,10 +300,592 @@ func TestE2EListTemplateDisable(t *testing.T) {
 
 func TestE2EMbox(t *testing.T) {
 	data := walkSources(
-		[]string{"./testdata/endtoend"},
-		[]*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+		mailSources("./testdata/endtoend"),
+		parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}},
 		nil,
 	)
-	classeddata := calculateRanks(data, nil, nil)
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
 	assert.Contains(t, classeddata[0], "git@vger.kernel.org")
 }
+
+func TestE2ERankingFrecency(t *testing.T) {
+	halfLife := 30 * 24 * time.Hour
+	data := walkSources(
+		mailSources("./testdata/endtoend"),
+		parseOptions{
+			useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+			halfLife:      halfLife,
+		},
+		nil,
+	)
+	now, _ := time.Parse(time.RFC1123Z, "Tue, 07 Jan 2025 14:29:08 -0500")
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{
+		ranker:   frecencyRanker{},
+		halfLife: halfLife,
+		now:      now,
+	})
+
+	assert.InDelta(t, 1+math.Pow(2, -0.1), classeddata[2]["friend1@friends.com"].FrecencyScore, 1e-9)
+	tests := []struct {
+		testname string
+		lower    string
+		higher   string
+	}{
+		{"class 2 check 1", "friend1@friends.com", "friend3@friends.com"},
+		{"class 2 check 2", "friend3@friends.com", "friend4@friends.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			assert.Less(
+				t,
+				classeddata[2][tt.lower].TotalRank,
+				classeddata[2][tt.higher].TotalRank,
+			)
+		})
+	}
+}
+
+func TestE2EJSONOutput(t *testing.T) {
+	data := walkSources(
+		mailSources("./testdata/endtoend"),
+		parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}},
+		nil,
+	)
+	addressbook := map[string]addressbookEntry{
+		"unmatched@example.com": {"Unmatched", "cmd"},
+		"another@example.com":   {"Another", "cmd"},
+		"friend1@friends.com":   {"Friend From Book", "cmd"},
+	}
+	classeddata := calculateRanks(data, addressbook, nil, rankingOptions{})
+	dir := t.TempDir()
+
+	type record struct {
+		Address   string
+		Names     []string
+		Class     int
+		ClassDate []*time.Time
+	}
+	jsonpath := filepath.Join(dir, "addressbook.json")
+	saveData(classeddata, jsonpath, "json", "", nil, addressbook, true)
+	content, err := os.ReadFile(jsonpath)
+	assert.NoError(t, err)
+	var records []record
+	assert.NoError(t, json.Unmarshal(content, &records))
+
+	ndjsonpath := filepath.Join(dir, "addressbook.ndjson")
+	saveData(classeddata, ndjsonpath, "ndjson", "", nil, addressbook, true)
+	content, err = os.ReadFile(ndjsonpath)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, len(records))
+	for i, line := range lines {
+		var r record
+		assert.NoError(t, json.Unmarshal([]byte(line), &r))
+		assert.Equal(t, records[i], r)
+	}
+
+	assert.Equal(t, len(data)+2, len(records))
+	assert.Equal(t, "friend1@friends.com", records[0].Address)
+	for _, r := range records[1:] {
+		assert.NotEqual(t, "friend1@friends.com", r.Address)
+	}
+	assert.Equal(t, 2, records[0].Class)
+	assert.Equal(t, "2025-01-07T19:29:08Z", records[0].ClassDate[2].Format(time.RFC3339))
+	assert.Nil(t, records[0].ClassDate[0])
+	assert.Equal(t, "another@example.com", records[len(records)-2].Address)
+	assert.Equal(t, "unmatched@example.com", records[len(records)-1].Address)
+}
+
+func TestE2EMuttAliasOutput(t *testing.T) {
+	data := walkSources(
+		mailSources("./testdata/endtoend"),
+		parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}},
+		nil,
+	)
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
+	path := filepath.Join(t.TempDir(), "aliases")
+	saveData(classeddata, path, "mutt-alias", "local", nil, nil, false)
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, len(data))
+	assert.Contains(t, lines[0], "alias friend1 ")
+	assert.True(t, strings.HasSuffix(lines[0], "<friend1@friends.com>"))
+	keys := make(map[string]bool)
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		assert.Equal(t, "alias", fields[0])
+		assert.False(t, keys[fields[1]], fields[1])
+		keys[fields[1]] = true
+	}
+}
+
+func TestE2EVCardAddressbook(t *testing.T) {
+	addressbook := parseAddressbook([]addressbookSource{
+		{Name: "khard", Type: "vcard", Path: "./testdata/addressbook/vdir"},
+	})
+	data := walkSources(mailSources("./testdata/endtoend"), parseOptions{}, nil)
+	classeddata := calculateRanks(data, addressbook, nil, rankingOptions{})
+
+	tests := []struct {
+		testname string
+		class    int
+		address  string
+		want     string
+	}{
+		{"formatted name", 2, "foo@bar.com", "Foo Bar, the Example"},
+		{"structured name", 2, "nobody@anonymous.com", "Nobody Anonymous"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			addr, ok := classeddata[tt.class][tt.address]
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, addr.Name)
+		})
+	}
+}
+
+func TestE2EAddressbookPrecedence(t *testing.T) {
+	addressbook := parseAddressbook([]addressbookSource{
+		{Name: "company", Type: "csv", Path: "./testdata/addressbook/contacts.csv", NameColumn: "full name", EmailColumn: "email"},
+		{Name: "directory", Type: "cmd", Command: "cat ./testdata/addressbook/command.tsv"},
+		{Name: "khard", Type: "vcard", Path: "./testdata/addressbook/vdir"},
+	})
+	data := walkSources(mailSources("./testdata/endtoend"), parseOptions{}, nil)
+	classeddata := calculateRanks(data, addressbook, nil, rankingOptions{})
+
+	tests := []struct {
+		testname string
+		class    int
+		address  string
+		want     string
+		source   string
+	}{
+		{"first source wins", 2, "nobody@anonymous.com", "Nobody From CSV", "company"},
+		{"second source", 2, "foo@bar.com", "Foo From Command", "directory"},
+		{"not in any source", 2, "something@example.com", "Example", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			addr, ok := classeddata[tt.class][tt.address]
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, addr.Name)
+			assert.Equal(t, tt.source, addr.NameSource)
+		})
+	}
+}
+
+func TestE2ENotmuch(t *testing.T) {
+	bin, err := filepath.Abs(filepath.Join("testdata", "notmuch"))
+	assert.NoError(t, err)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	argsPath := filepath.Join(t.TempDir(), "args")
+	t.Setenv("NOTMUCH_TEST_ARGS", argsPath)
+
+	data := walkSources(
+		mailSources("notmuch:tag:inbox or tag:sent"),
+		parseOptions{
+			useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+			excludeTags:   []string{"spam", "deleted"},
+		},
+		nil,
+	)
+	args, err := os.ReadFile(argsPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(args), "\n--body=false\n")
+	assert.True(t, strings.HasSuffix(string(args), "\n(tag:inbox or tag:sent) and not tag:spam and not tag:deleted\n"))
+
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
+	tests := []struct {
+		testname string
+		class    int
+		address  string
+		want     string
+	}{
+		{"to", 2, "nmfriend@friends.com", "Notmuch Friend"},
+		{"cc", 1, "jane@friends.com", "Doe, Jane"},
+		{"reply-to", 0, "arpad@friends.com", "Árpád"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			addr, ok := classeddata[tt.class][tt.address]
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, addr.Name)
+		})
+	}
+	assert.Equal(t, int64(1736018948), classeddata[2]["nmfriend@friends.com"].ClassDate[2])
+	assert.NotContains(t, data, "spammer@spam.com")
+}
+
+func TestE2ESourceOptions(t *testing.T) {
+	sources := []mailSource{
+		{
+			Path:    "./testdata/endtoend",
+			Label:   "archive",
+			Exclude: []string{"not_from_me", "*.mbox", "lists_*", "diacritics_*"},
+			Weight:  2,
+			Since:   "2024-01-01",
+		},
+		{Path: "./testdata/endtoend/not_from_me", Label: "sent", Sent: true},
+	}
+	for i := range sources {
+		assert.NoError(t, sources[i].validate(nil, nil))
+	}
+	opts := parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}}
+	cachepath := filepath.Join(t.TempDir(), "cache.gob")
+
+	tests := []struct {
+		testname string
+		address  string
+		class    int
+		count    []int
+		sources  []string
+	}{
+		{"weighted to", "friend1@friends.com", 2, []int{0, 0, 4}, []string{"archive"}},
+		{"weighted cc", "friend4@friends.com", 1, []int{0, 2, 0}, []string{"archive"}},
+		{"sent to", "nobody@anonymous.com", 2, []int{0, 0, 1}, []string{"sent"}},
+		{"sent from", "foo@bar.com", 0, []int{1, 0, 0}, []string{"sent"}},
+		{"before since", "friend3@friends.com", 2, []int{0, 0, 2}, []string{"archive"}},
+		{"in both", "me@myself.me", 2, []int{4, 0, 2}, []string{"archive", "sent"}},
+	}
+	for _, run := range []string{"uncached", "cold cache", "warm cache"} {
+		var cache *addressCache
+		if run != "uncached" {
+			cache = loadCache(cachepath, cacheFingerprint(opts, sources), false)
+		}
+		data := walkSources(sources, opts, cache)
+		assert.NoError(t, cache.save())
+		classeddata := calculateRanks(data, nil, nil, rankingOptions{})
+		for _, tt := range tests {
+			t.Run(run+"/"+tt.testname, func(t *testing.T) {
+				addr, ok := classeddata[tt.class][tt.address]
+				assert.True(t, ok)
+				assert.Equal(t, tt.count, addr.ClassCount)
+				assert.ElementsMatch(t, tt.sources, addr.Sources)
+			})
+		}
+		for _, class := range classeddata {
+			assert.NotContains(t, class, "somelist-devel@lists.sourceforge.net")
+		}
+	}
+}
+
+func TestE2EOverlappingSources(t *testing.T) {
+	// the sent folder is read as part of the archive as well
+	sources := []mailSource{
+		{Path: "./testdata/endtoend", Label: "archive"},
+		{Path: "./testdata/endtoend/not_from_me", Label: "sent", Sent: true},
+	}
+	for i := range sources {
+		assert.NoError(t, sources[i].validate(nil, nil))
+	}
+	opts := parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}}
+	cachepath := filepath.Join(t.TempDir(), "cache.gob")
+
+	for _, run := range []string{"uncached", "cold cache", "warm cache"} {
+		t.Run(run, func(t *testing.T) {
+			var cache *addressCache
+			if run != "uncached" {
+				cache = loadCache(cachepath, cacheFingerprint(opts, sources), false)
+			}
+			data := walkSources(sources, opts, cache)
+			assert.NoError(t, cache.save())
+			classeddata := calculateRanks(data, nil, nil, rankingOptions{})
+			nobody := classeddata[2]["nobody@anonymous.com"]
+			assert.Equal(t, []int{1, 0, 1}, nobody.ClassCount)
+			assert.ElementsMatch(t, []string{"archive", "sent"}, nobody.Sources)
+		})
+	}
+}
+
+func TestE2ETimeWindow(t *testing.T) {
+	data := walkSources(
+		mailSources("./testdata/endtoend"),
+		parseOptions{
+			useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+			since:         time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			until:         time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC),
+		},
+		nil,
+	)
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
+	assert.Equal(t, []int{0, 0, 1}, classeddata[2]["friend1@friends.com"].ClassCount)
+	assert.Equal(t, []int{0, 1, 0}, classeddata[1]["friend2@friends.com"].ClassCount)
+	assert.Contains(t, classeddata[0], "something@example.com")
+	for _, class := range classeddata {
+		assert.NotContains(t, class, "friend4@friends.com")
+		assert.NotContains(t, class, "foo@bar.com")
+	}
+}
+
+func TestE2EHeaders(t *testing.T) {
+	useraddresses := []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}
+	data := walkSources(
+		mailSources("./testdata/headers"),
+		parseOptions{useraddresses: useraddresses},
+		nil,
+	)
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
+
+	tests := []struct {
+		testname string
+		address  string
+		class    int
+		count    []int
+	}{
+		{"Resent-To", "colleague@work.com", 2, []int{0, 0, 1}},
+		{"Resent-Cc", "boss@work.com", 1, []int{0, 1, 0}},
+		{"To not from me", "me@myself.me", 0, []int{2, 0, 0}},
+		{"From", "someone@example.org", 0, []int{1, 0, 0}},
+		{"To and Mail-Followup-To", "list@lists.example.org", 2, []int{0, 0, 1}},
+		{"Mail-Followup-To", "poster@example.net", 2, []int{0, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			assert.Equal(t, tt.count, classeddata[tt.class][tt.address].ClassCount)
+		})
+	}
+
+	data = walkSources(
+		mailSources("./testdata/headers"),
+		parseOptions{useraddresses: useraddresses, headers: []string{"to", "cc", "bcc", "from"}},
+		nil,
+	)
+	classeddata = calculateRanks(data, nil, nil, rankingOptions{})
+	for _, class := range classeddata {
+		assert.NotContains(t, class, "colleague@work.com")
+		assert.NotContains(t, class, "poster@example.net")
+	}
+	assert.Equal(t, []int{0, 0, 1}, classeddata[2]["list@lists.example.org"].ClassCount)
+}
+
+func TestE2EClasses(t *testing.T) {
+	data := walkSources(
+		mailSources("./testdata/endtoend"),
+		parseOptions{
+			useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+			classes: classRules{
+				{Fields: []string{"to", "bcc"}, FromMe: boolPtr(true)},
+				{Fields: []string{"cc"}, FromMe: boolPtr(true)},
+				{Fields: []string{"from"}, FromMe: boolPtr(false), ToMe: boolPtr(true), List: boolPtr(false)},
+			},
+		},
+		nil,
+	)
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
+	assert.Len(t, classeddata, 4)
+
+	tests := []struct {
+		testname string
+		address  string
+		class    int
+		count    []int
+	}{
+		{"to", "friend1@friends.com", 3, []int{0, 0, 1, 2}},
+		{"cc", "friend2@friends.com", 2, []int{0, 0, 2, 0}},
+		{"wrote to me", "foo@bar.com", 1, []int{0, 1, 0, 0}},
+		{"list", "git@vger.kernel.org", 0, []int{5, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			addr, ok := classeddata[tt.class][tt.address]
+			assert.True(t, ok)
+			assert.Equal(t, tt.count, addr.ClassCount)
+		})
+	}
+}
+
+func TestE2EReplies(t *testing.T) {
+	data := walkSources(
+		mailSources("./testdata/replies"),
+		parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}},
+		nil,
+	)
+
+	tests := []struct {
+		testname string
+		address  string
+		count    int
+		date     int64
+	}{
+		{"In-Reply-To, copy in archive counted once", "alice@example.com", 1, 1735984800},
+		{"References", "bob@example.com", 1, 1736071200},
+		{"not replied to", "carol@example.com", 0, 0},
+		{"own messages", "me@myself.me", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			assert.Equal(t, tt.count, data[tt.address].ReplyCount)
+			assert.Equal(t, tt.date, data[tt.address].ReplyDate)
+		})
+	}
+
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
+	assert.Equal(t, 0, classeddata[2]["bob@example.com"].ReplyRank)
+	assert.Equal(t, 1, classeddata[2]["alice@example.com"].ReplyRank)
+
+	data = walkSources(
+		mailSources("./testdata/replies"),
+		parseOptions{
+			useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+			until:         time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC),
+		},
+		nil,
+	)
+	assert.Equal(t, 1, data["alice@example.com"].ReplyCount)
+	assert.Equal(t, 0, data["bob@example.com"].ReplyCount)
+}
+
+func TestE2EAutomated(t *testing.T) {
+	useraddresses := []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}
+	// Without addresses of the user every address would be in the best
+	// class, which shows the demotion.
+	data := walkSources(
+		mailSources("./testdata/automated"),
+		parseOptions{
+			automated: map[string]string{
+				"precedence":               "demote",
+				"auto-submitted":           "drop",
+				"list-unsubscribe":         "tag",
+				"x-auto-response-suppress": "tag",
+				"feedback-id":              "tag",
+			},
+		},
+		nil,
+	)
+
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
+
+	tests := []struct {
+		testname    string
+		address     string
+		present     bool
+		class       int
+		isAutomated bool
+		count       []int
+	}{
+		{"tagged", "news@shop.example", true, 2, true, []int{0, 0, 1}},
+		{"dropped", "notifications@service.example", false, 0, false, nil},
+		{"dropped reply-to", "support@service.example", false, 0, false, nil},
+		{"demoted", "announce@corp.example", true, 0, true, []int{1, 0, 0}},
+		{"out of office tagged", "colleague@corp.example", true, 2, true, []int{0, 0, 2}},
+		{"recipient", "me@myself.me", true, 2, false, []int{0, 0, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			if !tt.present {
+				for _, class := range classeddata {
+					assert.NotContains(t, class, tt.address)
+				}
+				return
+			}
+			aD, ok := classeddata[tt.class][tt.address]
+			assert.True(t, ok)
+			assert.Equal(t, tt.isAutomated, aD.IsAutomated)
+			assert.Equal(t, tt.count, aD.ClassCount)
+		})
+	}
+
+	data = walkSources(mailSources("./testdata/automated"), parseOptions{useraddresses: useraddresses}, nil)
+	classeddata = calculateRanks(data, nil, nil, rankingOptions{})
+	assert.Contains(t, classeddata[0], "notifications@service.example")
+	assert.False(t, classeddata[0]["news@shop.example"].IsAutomated)
+	assert.Contains(t, classeddata[0], "news@shop.example")
+}
+
+func TestE2ECanonicalize(t *testing.T) {
+	useraddresses := []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}
+	data := walkSources(
+		mailSources("./testdata/canonical"),
+		parseOptions{useraddresses: useraddresses, canonicalize: canonicalRules},
+		nil,
+	)
+	classeddata := calculateRanks(data, nil, nil, rankingOptions{})
+
+	tests := []struct {
+		testname string
+		key      string
+		class    int
+		address  string
+		count    []int
+	}{
+		{"gmail", "janedoe@gmail.com", 2, "jane.doe@gmail.com", []int{2, 0, 3}},
+		{"idn", "info@bücher.example", 1, "info@xn--bcher-kva.example", []int{1, 1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			aD, ok := classeddata[tt.class][tt.key]
+			assert.True(t, ok)
+			assert.Equal(t, tt.address, aD.Address)
+			assert.Equal(t, tt.count, aD.ClassCount)
+		})
+	}
+	assert.Len(t, data, 3)
+
+	data = walkSources(mailSources("./testdata/canonical"), parseOptions{useraddresses: useraddresses}, nil)
+	assert.Len(t, data, 6)
+}
+
+func TestE2ENamePolicy(t *testing.T) {
+	data := walkSources(
+		mailSources("./testdata/names"),
+		parseOptions{
+			useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")},
+			halfLife:      30 * 24 * time.Hour,
+		},
+		nil,
+	)
+	now := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		policy   string
+		halfLife time.Duration
+		want     string
+	}{
+		{"", 30 * 24 * time.Hour, "Jane S."},
+		{"frequent", 30 * 24 * time.Hour, "Jane S."},
+		{"recent", 30 * 24 * time.Hour, "Jane Jones"},
+		{"provenance", 30 * 24 * time.Hour, "Jane Smith"},
+		{"weighted", 30 * 24 * time.Hour, "Jane Jones"},
+		{"weighted", 0, "Jane Smith"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			classeddata := calculateRanks(data, nil, nil, rankingOptions{
+				halfLife:   tt.halfLife,
+				now:        now,
+				namePolicy: tt.policy,
+			})
+			assert.Equal(t, tt.want, classeddata[2]["jane@corp.example"].Name)
+		})
+	}
+}
+
+func TestE2ENameCleanup(t *testing.T) {
+	data := walkSources(
+		mailSources("./testdata/namecleanup"),
+		parseOptions{useraddresses: []*regexp.Regexp{regexp.MustCompile(".+@myself.me")}},
+		nil,
+	)
+
+	tests := []struct {
+		steps []string
+		jane  string
+		bob   string
+	}{
+		{nil, "Doe, Jane", "bob"},
+		{[]string{"whitespace", "address"}, "Doe, Jane", "Bob Stone"},
+		{nameCleanupSteps, "Jane Doe", "Bob Stone"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.steps, ","), func(t *testing.T) {
+			classeddata := calculateRanks(data, nil, nil, rankingOptions{nameCleanup: tt.steps})
+			assert.Equal(t, tt.jane, classeddata[2]["jane@corp.example"].Name)
+			assert.Equal(t, tt.bob, classeddata[2]["bob@corp.example"].Name)
+		})
+	}
+}
@@ -1,17 +1,77 @@
 package main
 
-func main() {
-	config := loadConfig()
-	addressbook := parseAddressbook(config.addressbookLookupCommand)
-	data := walkSources(
-		config.maildirs,
-		config.useraddresses,
-		config.customFilters,
-	)
+import (
+	"fmt"
+	"os"
+)
+
+// writeAddressbook ranks the collected addresses and writes the result.
+func writeAddressbook(
+	data map[string]AddressData,
+	addressbook map[string]addressbookEntry,
+	config Config,
+) {
 	classeddata := calculateRanks(
 		data,
 		addressbook,
 		config.listtemplate,
+		config.rankingOptions(),
+	)
+	ranked := saveData(classeddata, config.outputpath, config.format, config.aliasKey, config.template, addressbook, config.addressbookAddUnmatched)
+	if config.queryData && config.outputpath != "-" {
+		if err := saveSidecar(ranked, config.outputpath); err != nil {
+			fmt.Fprintln(os.Stderr, "Couldn't save data for queries:", err)
+		}
+	}
+}
+
+func main() {
+	config := loadConfig()
+	var err error
+	switch config.command {
+	case "discover":
+		err = runDiscover(config)
+	case "query":
+		err = runQuery(config, config.args[1:])
+	case "serve":
+		err = runServer(config)
+	case "client":
+		err = runClient(config, config.args[1:])
+	default:
+		err = runScan(config)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func runScan(config Config) error {
+	if len(config.useraddresses) == 0 && config.discoverAddresses {
+		found := discoverAddresses(config.sources)
+		config.useraddresses = discoveredRegexps(found)
+		fmt.Println("Using", len(found), "discovered addresses of yours, see the discover command.")
+	}
+	if len(config.useraddresses) == 0 {
+		fmt.Fprintln(os.Stderr, "No addresses of yours are known, so every address is ranked in the same class. Set addresses or see the discover command.")
+	}
+	addressbook := parseAddressbook(config.addressbooks)
+	cache := loadCache(
+		config.cachepath,
+		cacheFingerprint(config.parseOptions(), config.sources),
+		config.rebuildCache,
+	)
+	data := walkSources(
+		config.sources,
+		config.parseOptions(),
+		cache,
 	)
-	saveData(classeddata, config.outputpath, config.template, addressbook, config.addressbookAddUnmatched)
+	if err := cache.save(); err != nil {
+		fmt.Fprintln(os.Stderr, "Couldn't save cache:", err)
+	}
+	writeAddressbook(data, addressbook, config)
+	if config.watch {
+		return watchSources(data, addressbook, config)
+	}
+	return nil
 }
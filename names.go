@@ -0,0 +1,252 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The provenances of a name, from the least to the most trusted: used by
+// someone else for the address, used by the address in its own From or Sender
+// header, or typed by the user when writing to the address.
+const (
+	nameByOthers = iota
+	nameBySelf
+	nameByMe
+)
+
+// nameProvenanceWeights are how much a use of a name counts with the weighted
+// policy, by provenance.
+var nameProvenanceWeights = [3]float64{1, 2, 4}
+
+// namePolicies are the ways a name is chosen among the names seen for an
+// address, see selectName.
+var namePolicies = []string{"frequent", "recent", "provenance", "weighted"}
+
+// selfNameFields are the headers where the name of an address is chosen by
+// its owner.
+var selfNameFields = []string{"from", "sender", "resent-from", "resent-sender"}
+
+// nameUse records how often and how recently a name was used for an address,
+// by provenance. Decay holds decayed sums as ClassDecay does.
+type nameUse struct {
+	Count [3]int
+	Decay [3]float64
+	Date  int64
+}
+
+// addNameUse records a use of name for aD in a message sent at date, decay
+// being the decayed weight of the message.
+func addNameUse(aD *AddressData, name string, provenance int, date int64, weight int, decay float64) {
+	if aD.NameUses == nil {
+		aD.NameUses = make(map[string]nameUse)
+	}
+	use := aD.NameUses[name]
+	use.Decay[provenance] = mergeDecay(use.Decay[provenance], use.Count[provenance] > 0, decay)
+	use.Count[provenance] += weight
+	use.Date = max(use.Date, date)
+	aD.NameUses[name] = use
+}
+
+// mergeNameUses adds the name uses of b to a.
+func mergeNameUses(a map[string]nameUse, b map[string]nameUse) map[string]nameUse {
+	if len(b) > 0 && a == nil {
+		a = make(map[string]nameUse)
+	}
+	for name, use := range b {
+		sum := a[name]
+		for p := range sum.Count {
+			if use.Count[p] > 0 {
+				sum.Decay[p] = mergeDecay(sum.Decay[p], sum.Count[p] > 0, use.Decay[p])
+			}
+			sum.Count[p] += use.Count[p]
+		}
+		sum.Date = max(sum.Date, use.Date)
+		a[name] = sum
+	}
+	return a
+}
+
+// cleanName is the form a name is output in.
+func cleanName(name string) string {
+	name = strings.TrimSpace(name)
+	return strings.Replace(name, "\"", "", -1)
+}
+
+// nameScore is the weighted score of a name: the sum of its uses weighted by
+// provenance, each decaying with its age like with frecency ranking.
+// Without a half-life uses do not decay.
+func nameScore(use nameUse, ranking rankingOptions) float64 {
+	score := 0.0
+	for p, weight := range nameProvenanceWeights {
+		if ranking.halfLife <= 0 {
+			score += weight * float64(use.Count[p])
+		} else {
+			score += weight * frecencyScore(use.Decay[p], use.Count[p] > 0, ranking.now, ranking.halfLife)
+		}
+	}
+	return score
+}
+
+// selectName chooses the name of an address according to the name policy:
+//
+//   - frequent: the name used most often
+//   - recent: the name used most recently
+//   - provenance: the name typed by the user, or else the one the address
+//     uses itself, or else the one others use, the most recent one if there
+//     are several
+//   - weighted: the name with the highest nameScore
+//
+// Ties are broken by the number of uses, then alphabetically.
+func selectName(aD AddressData, ranking rankingOptions) string {
+	if ranking.namePolicy == "" || ranking.namePolicy == "frequent" || len(aD.NameUses) == 0 {
+		return getMostFrequent(aD.Names)
+	}
+	if ranking.now.IsZero() {
+		ranking.now = time.Now()
+	}
+	type candidate struct {
+		name       string
+		use        nameUse
+		count      int
+		provenance int
+		score      float64
+	}
+	candidates := make([]candidate, 0, len(aD.NameUses))
+	for name, use := range aD.NameUses {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		c := candidate{name: name, use: use, provenance: -1}
+		for p, count := range use.Count {
+			c.count += count
+			if count > 0 {
+				c.provenance = p
+			}
+		}
+		if ranking.namePolicy == "weighted" {
+			c.score = nameScore(use, ranking)
+		}
+		candidates = append(candidates, c)
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch ranking.namePolicy {
+		case "provenance":
+			if a.provenance != b.provenance {
+				return a.provenance > b.provenance
+			}
+			if a.use.Date != b.use.Date {
+				return a.use.Date > b.use.Date
+			}
+		case "recent":
+			if a.use.Date != b.use.Date {
+				return a.use.Date > b.use.Date
+			}
+		case "weighted":
+			if a.score != b.score {
+				return a.score > b.score
+			}
+		}
+		if a.count != b.count {
+			return a.count > b.count
+		}
+		return a.name < b.name
+	})
+	return cleanName(candidates[0].name)
+}
+
+// nameCleanupSteps are the steps names can be cleaned up with, in the order
+// they are applied, see cleanupName.
+var nameCleanupSteps = []string{"via", "role", "last-first", "whitespace", "address"}
+
+var (
+	viaPattern        = regexp.MustCompile(`(?i)^(.*\S)\s+via\s+\S.*$`)
+	rolePattern       = regexp.MustCompile(`^(.*\S)\s*(\([^()]*\)|\[[^\[\]]*\])$`)
+	whitespacePattern = regexp.MustCompile(`\s+`)
+)
+
+// nameSuffixes are what can follow a comma in a name without it being in the
+// "Last, First" form.
+var nameSuffixes = []string{"inc", "inc.", "ltd", "ltd.", "llc", "gmbh", "jr", "jr.", "sr", "sr.", "phd", "ph.d."}
+
+// cleanupName applies those of the steps to a name used for address, in the
+// order of nameCleanupSteps whatever the order of steps:
+//
+//   - via: strip the "via somelist" mailing lists and Google Groups add, along
+//     with the quotes around the name before it
+//   - role: strip a trailing "(Company)" or "[Role]"
+//   - last-first: turn "Doe, Jane" into "Jane Doe"
+//   - whitespace: collapse runs of whitespace into a single space
+//   - address: drop the name if it is just the address or its local part
+//
+// An empty string means that the name is dropped.
+func cleanupName(name string, address string, steps []string) string {
+	name = strings.TrimSpace(name)
+	for _, step := range nameCleanupSteps {
+		if !slices.Contains(steps, step) {
+			continue
+		}
+		switch step {
+		case "via":
+			if match := viaPattern.FindStringSubmatch(name); match != nil {
+				// Google Groups quotes the name it puts before via
+				name = strings.Trim(match[1], `"' `)
+			}
+		case "last-first":
+			last, first, ok := strings.Cut(name, ",")
+			last, first = strings.TrimSpace(last), strings.TrimSpace(first)
+			if ok && last != "" && first != "" && !strings.Contains(first, ",") &&
+				!slices.Contains(nameSuffixes, strings.ToLower(first)) {
+				name = first + " " + last
+			}
+		case "whitespace":
+			name = whitespacePattern.ReplaceAllString(name, " ")
+		case "role":
+			name = rolePattern.ReplaceAllString(name, "$1")
+		case "address":
+			bare := strings.ToLower(strings.Trim(name, "\"'<> "))
+			local, _, _ := strings.Cut(address, "@")
+			if bare == address || bare == local {
+				name = ""
+			}
+		}
+		name = strings.TrimSpace(name)
+	}
+	return name
+}
+
+// cleanupNames applies cleanupName to every name of aD, whose address is
+// normaddr, merging the uses of names which become the same.
+func cleanupNames(aD AddressData, normaddr string, steps []string) AddressData {
+	if len(steps) == 0 {
+		return aD
+	}
+	addresses := []string{normaddr, strings.ToLower(aD.Address)}
+	cleanup := func(name string) string {
+		for _, address := range addresses {
+			name = cleanupName(name, address, steps)
+		}
+		return name
+	}
+	names := make([]string, 0, len(aD.Names))
+	for _, name := range aD.Names {
+		if name = cleanup(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	var uses map[string]nameUse
+	for name, use := range aD.NameUses {
+		if name = cleanup(name); name != "" {
+			uses = mergeNameUses(uses, map[string]nameUse{name: use})
+		}
+	}
+	aD.Names = names
+	aD.NameUses = uses
+	return aD
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectName(t *testing.T) {
+	halfLife := 30 * 24 * time.Hour
+	now := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	use := func(provenance int, count int, date time.Time) nameUse {
+		u := nameUse{Date: date.Unix()}
+		u.Count[provenance] = count
+		u.Decay[provenance] = addDecay(0, false, date.Unix(), halfLife)
+		for i := 1; i < count; i++ {
+			u.Decay[provenance] = addDecay(u.Decay[provenance], true, date.Unix(), halfLife)
+		}
+		return u
+	}
+	aD := AddressData{
+		Names: []string{"Old", "Old", "Old", "Own", "Typed"},
+		NameUses: map[string]nameUse{
+			"Old":      use(nameByOthers, 3, now.AddDate(0, -1, 0)),
+			" \"Own\"": use(nameBySelf, 1, now.AddDate(0, 0, -1)),
+			"Typed":    use(nameByMe, 1, now.AddDate(-1, 0, 0)),
+			" ":        use(nameByMe, 5, now),
+		},
+	}
+
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{"frequent", "Old"},
+		{"recent", "Own"},
+		{"provenance", "Typed"},
+		{"weighted", "Own"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			got := selectName(aD, rankingOptions{halfLife: halfLife, now: now, namePolicy: tt.policy})
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	assert.Equal(t, "Old", selectName(AddressData{Names: aD.Names}, rankingOptions{namePolicy: "recent"}))
+}
+
+func TestCleanupName(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []string
+		want  string
+	}{
+		{"Jane Doe via dev-list", []string{"via"}, "Jane Doe"},
+		{"'Jane Doe' via Google Groups", []string{"via"}, "Jane Doe"},
+		{"\"Jane Doe\" via dev-list", []string{"via"}, "Jane Doe"},
+		{"Jane O'Doe via dev-list", []string{"via"}, "Jane O'Doe"},
+		{"Doe, Jane", []string{"last-first"}, "Jane Doe"},
+		{"Doe , Jane Ann", []string{"last-first"}, "Jane Ann Doe"},
+		{"Acme, Inc.", []string{"last-first"}, "Acme, Inc."},
+		{"Doe, Jane, Ann", []string{"last-first"}, "Doe, Jane, Ann"},
+		{"  Jane \t Doe ", []string{"whitespace"}, "Jane Doe"},
+		{"Jane Doe (Acme)", []string{"role"}, "Jane Doe"},
+		{"Jane Doe [Support]", []string{"role"}, "Jane Doe"},
+		{"(Acme)", []string{"role"}, "(Acme)"},
+		{"jane@example.com", []string{"address"}, ""},
+		{"'Jane'", []string{"address"}, ""},
+		{"Jane Doe", []string{"address"}, "Jane Doe"},
+		{"Doe,  Jane (Acme) via dev", nameCleanupSteps, "Jane Doe"},
+		{"jane via dev", nameCleanupSteps, ""},
+		{"Doe, Jane via dev", []string{"last-first", "via"}, "Jane Doe"},
+		{"Doe, Jane", nil, "Doe, Jane"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, cleanupName(tt.name, "jane@example.com", tt.steps))
+		})
+	}
+}
+
+func TestCleanupNames(t *testing.T) {
+	aD := AddressData{
+		Address: "Jane@Example.com",
+		Names:   []string{"Doe, Jane", "Jane Doe (Acme)", "jane"},
+		NameUses: map[string]nameUse{
+			"Doe, Jane":       {Count: [3]int{1, 0, 0}, Date: 10},
+			"Jane Doe (Acme)": {Count: [3]int{0, 2, 0}, Date: 20},
+			"jane":            {Count: [3]int{0, 0, 3}, Date: 30},
+		},
+	}
+	got := cleanupNames(aD, "jane@example.com", nameCleanupSteps)
+	assert.Equal(t, []string{"Jane Doe", "Jane Doe"}, got.Names)
+	assert.Equal(t, map[string]nameUse{"Jane Doe": {Count: [3]int{1, 2, 0}, Date: 20}}, got.NameUses)
+	assert.Equal(t, []string{"Doe, Jane", "Jane Doe (Acme)", "jane"}, aD.Names)
+	assert.Equal(t, aD, cleanupNames(aD, "jane@example.com", nil))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// notmuchPrefix marks entries of the maildir list which are notmuch queries
+// instead of paths.
+const notmuchPrefix = "notmuch:"
+
+// notmuchMessage is the part of a message in the output of notmuch show we
+// are interested in.
+type notmuchMessage struct {
+	ID        string            `json:"id"`
+	Match     bool              `json:"match"`
+	Timestamp int64             `json:"timestamp"`
+	Headers   map[string]string `json:"headers"`
+}
+
+// notmuchQuery combines the query of a source with the excluded tags.
+func notmuchQuery(query string, excludeTags []string) string {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		query = "*"
+	}
+	if len(excludeTags) == 0 {
+		return query
+	}
+	parts := []string{"(" + query + ")"}
+	for _, tag := range excludeTags {
+		parts = append(parts, "not tag:"+tag)
+	}
+	return strings.Join(parts, " and ")
+}
+
+// notmuchEnvelope turns a message from notmuch into the header processEnvelope
+// expects. The date is taken from notmuch, which has already parsed it. The
+// message ids are not among the headers notmuch outputs, they are taken from
+// the message and the parent it has in the thread, if any.
+func notmuchEnvelope(msg notmuchMessage, parent string) *mail.Header {
+	h := &mail.Header{}
+	for key, value := range msg.Headers {
+		h.Set(key, value)
+	}
+	h.Set("Date", time.Unix(msg.Timestamp, 0).Format(time.RFC1123Z))
+	h.SetMessageID(msg.ID)
+	if parent != "" {
+		h.SetMsgIDList("In-Reply-To", []string{parent})
+	}
+	return h
+}
+
+// notmuchThreadNode walks a node of a thread, which is a message (null or
+// not matching if it was not asked for) followed by the list of its replies.
+// parent is the id of the message the node replies to, if known.
+func notmuchThreadNode(raw json.RawMessage, parent string, envelopes chan<- envelope) error {
+	var node []json.RawMessage
+	if err := json.Unmarshal(raw, &node); err != nil {
+		return err
+	}
+	if len(node) == 0 {
+		return nil
+	}
+	var msg *notmuchMessage
+	if err := json.Unmarshal(node[0], &msg); err != nil {
+		return err
+	}
+	if msg != nil && msg.Match {
+		envelopes <- envelope{path: notmuchPrefix + msg.ID, header: notmuchEnvelope(*msg, parent)}
+	}
+	parent = ""
+	if msg != nil {
+		parent = msg.ID
+	}
+	if len(node) < 2 {
+		return nil
+	}
+	var replies []json.RawMessage
+	if err := json.Unmarshal(node[1], &replies); err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err := notmuchThreadNode(reply, parent, envelopes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readNotmuchShow sends every matching message in the JSON output of notmuch
+// show to envelopes. The output is a list of threads, each of which is a list
+// of thread nodes. Threads are decoded one at a time, so that the output of
+// a large database is never held in memory at once.
+func readNotmuchShow(r io.Reader, envelopes chan<- envelope) error {
+	dec := json.NewDecoder(r)
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		var thread []json.RawMessage
+		if err := dec.Decode(&thread); err != nil {
+			return err
+		}
+		for _, node := range thread {
+			if err := notmuchThreadNode(node, "", envelopes); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// walkNotmuch collects the addresses of the messages matching query in the
+// notmuch database, leaving out messages with any of the excluded tags. The
+// database is the one notmuch finds by itself, which can be changed with
+// NOTMUCH_CONFIG.
+func walkNotmuch(
+	query string,
+	opts parseOptions,
+	threads *threadIndex,
+) map[string]AddressData {
+	envelopechan := make(chan envelope)
+	retvalchan := make(chan map[string]*fileContribution)
+	go processEnvelopeChan(envelopechan, retvalchan, false, opts)
+
+	cmd := exec.Command(
+		"notmuch", "show",
+		"--format=json",
+		"--body=false",
+		"--entire-thread=false",
+		"--exclude=false",
+		"--",
+		notmuchQuery(query, opts.excludeTags),
+	)
+	cmd.Stderr = os.Stderr
+	err := func() error {
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		if err := readNotmuchShow(out, envelopechan); err != nil {
+			io.Copy(io.Discard, out)
+			cmd.Wait()
+			return err
+		}
+		return cmd.Wait()
+	}()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "notmuch:", err)
+	}
+	close(envelopechan)
+
+	contributions := <-retvalchan
+	if contribution, ok := contributions[""]; ok {
+		threads.merge(contribution.Threads)
+		return contribution.Addresses
+	}
+	return make(map[string]AddressData)
+}
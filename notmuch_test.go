@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotmuchQuery(t *testing.T) {
+	tests := []struct {
+		query       string
+		excludeTags []string
+		want        string
+	}{
+		{"", nil, "*"},
+		{" ", []string{"spam"}, "(*) and not tag:spam"},
+		{"folder:work", []string{"spam", "deleted"}, "(folder:work) and not tag:spam and not tag:deleted"},
+		{"tag:a or tag:b", nil, "tag:a or tag:b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			assert.Equal(t, tt.want, notmuchQuery(tt.query, tt.excludeTags))
+		})
+	}
+}
+
+func TestReadNotmuchShowThreads(t *testing.T) {
+	f, err := os.Open("./testdata/notmuch/show.json")
+	assert.NoError(t, err)
+	defer f.Close()
+	envelopes := make(chan envelope)
+	go func() {
+		assert.NoError(t, readNotmuchShow(f, envelopes))
+		close(envelopes)
+	}()
+	parents := map[string][]string{}
+	for e := range envelopes {
+		id, err := e.header.MessageID()
+		assert.NoError(t, err)
+		parents[id] = replyParents(e.header)
+	}
+	assert.Equal(t, []string(nil), parents["1@myself.me"])
+	assert.Equal(t, []string{"1@myself.me"}, parents["2@friends.com"])
+}
@@ -1,47 +1,46 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 	"text/template"
+	"time"
 )
 
-func saveData(
+// sidecarPath is where the structured version of the output at path is kept
+// for the query subcommand, if query-data is set.
+func sidecarPath(path string) string {
+	return path + ".json"
+}
+
+// rankedAddresses flattens the classed data into the order of the output:
+// classes from highest to lowest, by rank within a class. With addUnmatched
+// the addressbook contacts not seen in any mail follow, ordered by address.
+func rankedAddresses(
 	classedData map[int]map[string]AddressData,
-	path string,
-	tmpl *template.Template,
-	addressbook map[string]string,
+	addressbook map[string]addressbookEntry,
 	addUnmatched bool,
-) {
-	var f *os.File
-	var err error
-	isstdout := path == "-"
-
-	if isstdout {
-		f = os.Stdout
-	} else {
-		os.MkdirAll(filepath.Dir(path), os.ModePerm)
-		f, err = os.Create(path)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	defer f.Close()
-
+) []AddressData {
 	type KeyValue struct {
 		Key   string
 		Value AddressData
 	}
-	count := 0
-	for class := 2; class >= 0; class-- {
+	ranked := []AddressData{}
+	matched := make(map[string]bool)
+	for class := len(classedData) - 1; class >= 0; class-- {
 		thisclass, _ := classedData[class]
 		s := make([]KeyValue, 0, len(thisclass))
 		for k, v := range thisclass {
 			s = append(s, KeyValue{k, v})
+			matched[k] = true
+			matched[strings.ToLower(v.Address)] = true
 		}
 		sort.SliceStable(s, func(i, j int) bool {
 			if s[i].Value.TotalRank == s[j].Value.TotalRank {
@@ -51,20 +50,156 @@ func saveData(
 			}
 		})
 		for _, kv := range s {
-			count++
-			tmpl.Execute(f, kv.Value)
+			ranked = append(ranked, kv.Value)
 		}
 	}
 	if addUnmatched {
-		for ak, av := range addressbook {
+		unmatched := make([]string, 0, len(addressbook))
+		for ak := range addressbook {
+			if !matched[ak] {
+				unmatched = append(unmatched, ak)
+			}
+		}
+		sort.Strings(unmatched)
+		for _, ak := range unmatched {
 			aD := AddressData{}
 			aD.Address = ak
-			aD.Name = av
-			count++
+			aD.Name = addressbook[ak].Name
+			aD.NameSource = addressbook[ak].Source
+			ranked = append(ranked, aD)
+		}
+	}
+	return ranked
+}
+
+// saveSidecar writes the structured data used by the query subcommand. Each
+// name is only kept once, as that is enough for searching. The file is
+// replaced atomically, so a running server never reads it half written.
+func saveSidecar(ranked []AddressData, path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(sidecarPath(path))+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	sidecar := make([]AddressData, len(ranked))
+	for i, aD := range ranked {
+		aD.Names = slices.Clone(aD.Names)
+		slices.Sort(aD.Names)
+		aD.Names = slices.Compact(aD.Names)
+		sidecar[i] = aD
+	}
+	if err := json.NewEncoder(f).Encode(sidecar); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), sidecarPath(path))
+}
+
+// jsonAddress is how AddressData is serialised by the json and ndjson
+// formats, with the class and reply dates as RFC 3339 timestamps.
+type jsonAddress struct {
+	AddressData
+	ClassDate []*time.Time
+	ReplyDate *time.Time
+}
+
+func newJSONAddress(aD AddressData) jsonAddress {
+	record := jsonAddress{AddressData: aD}
+	if aD.ReplyDate != 0 {
+		t := time.Unix(aD.ReplyDate, 0).UTC()
+		record.ReplyDate = &t
+	}
+	for _, date := range aD.ClassDate {
+		if date == 0 {
+			record.ClassDate = append(record.ClassDate, nil)
+		} else {
+			t := time.Unix(date, 0).UTC()
+			record.ClassDate = append(record.ClassDate, &t)
+		}
+	}
+	return record
+}
+
+// writeJSON writes the addresses as a single JSON array.
+func writeJSON(w io.Writer, ranked []AddressData) error {
+	records := make([]jsonAddress, len(ranked))
+	for i, aD := range ranked {
+		records[i] = newJSONAddress(aD)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// writeNDJSON writes the addresses as one JSON object per line.
+func writeNDJSON(w io.Writer, ranked []AddressData) error {
+	enc := json.NewEncoder(w)
+	for _, aD := range ranked {
+		if err := enc.Encode(newJSONAddress(aD)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveData writes the ranked addresses to path and returns them in the order
+// they were written.
+func saveData(
+	classedData map[int]map[string]AddressData,
+	path string,
+	format string,
+	aliasKey string,
+	tmpl *template.Template,
+	addressbook map[string]addressbookEntry,
+	addUnmatched bool,
+) []AddressData {
+	var f *os.File
+	var err error
+	isstdout := path == "-"
+
+	ranked := rankedAddresses(classedData, addressbook, addUnmatched)
+	if format == "vdir" {
+		if err := saveVdir(path, ranked); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(len(ranked), " addresses written to ", path)
+		return ranked
+	}
+
+	if isstdout {
+		f = os.Stdout
+	} else {
+		os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		f, err = os.Create(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	defer f.Close()
+
+	switch format {
+	case "json":
+		err = writeJSON(f, ranked)
+	case "ndjson":
+		err = writeNDJSON(f, ranked)
+	case "vcard":
+		err = writeVCards(f, ranked)
+	case "mutt-alias":
+		err = writeMuttAliases(f, ranked, aliasKey)
+	default:
+		for _, aD := range ranked {
 			tmpl.Execute(f, aD)
 		}
 	}
+	if err != nil {
+		log.Fatal(err)
+	}
 	if !isstdout {
-		fmt.Println(count, " addresses written to ", path)
+		fmt.Println(len(ranked), " addresses written to ", path)
 	}
+	return ranked
 }
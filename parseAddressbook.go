@@ -2,14 +2,35 @@ package main
 
 import (
 	"bufio"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"strings"
 )
 
-func parseAddressbook(
+// addressbookSource is a place names are looked up in. Sources are consulted
+// in the order they are configured, the first one knowing an address wins.
+type addressbookSource struct {
+	Name        string `mapstructure:"name"`
+	Type        string `mapstructure:"type"`
+	Command     string `mapstructure:"command"`
+	Path        string `mapstructure:"path"`
+	NameColumn  string `mapstructure:"name-column"`
+	EmailColumn string `mapstructure:"email-column"`
+}
+
+// addressbookEntry is a name from an addressbook together with the name of
+// the source it came from.
+type addressbookEntry struct {
+	Name   string
+	Source string
+}
+
+func parseAddressbookCommand(
 	cmd *exec.Cmd,
 ) map[string]string {
 	if cmd == nil {
@@ -37,3 +58,91 @@ func parseAddressbook(
 	}
 	return addressbook
 }
+
+// parseAddressbookCSV reads a CSV file with a header row, taking the names
+// and addresses from the columns with the given (case insensitive) headers.
+func parseAddressbookCSV(
+	path string,
+	nameColumn string,
+	emailColumn string,
+) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	nameIndex, emailIndex := -1, -1
+	for i, column := range header {
+		column = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		if strings.EqualFold(column, nameColumn) {
+			nameIndex = i
+		}
+		if strings.EqualFold(column, emailColumn) {
+			emailIndex = i
+		}
+	}
+	if nameIndex < 0 || emailIndex < 0 {
+		return nil, fmt.Errorf("%s: header needs a %q and an %q column", path, nameColumn, emailColumn)
+	}
+	addressbook := make(map[string]string)
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return addressbook, fmt.Errorf("%s: %w", path, err)
+		}
+		if nameIndex >= len(record) || emailIndex >= len(record) {
+			continue
+		}
+		email := strings.ToLower(strings.TrimSpace(record[emailIndex]))
+		name := strings.TrimSpace(record[nameIndex])
+		if email == "" || name == "" {
+			continue
+		}
+		if _, ok := addressbook[email]; !ok {
+			addressbook[email] = name
+		}
+	}
+	return addressbook, nil
+}
+
+// parseAddressbook reads every source and merges them, keeping the name from
+// the first source that knows an address.
+func parseAddressbook(
+	sources []addressbookSource,
+) map[string]addressbookEntry {
+	if len(sources) == 0 {
+		return nil
+	}
+	addressbook := make(map[string]addressbookEntry)
+	for _, source := range sources {
+		var names map[string]string
+		switch source.Type {
+		case "cmd":
+			args := strings.Fields(source.Command)
+			names = parseAddressbookCommand(exec.Command(args[0], args[1:]...))
+		case "vcard":
+			names = parseVCardAddressbook(source.Path)
+		case "csv":
+			var err error
+			names, err = parseAddressbookCSV(source.Path, source.NameColumn, source.EmailColumn)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+		for address, name := range names {
+			if _, ok := addressbook[address]; !ok {
+				addressbook[address] = addressbookEntry{name, source.Name}
+			}
+		}
+	}
+	return addressbook
+}
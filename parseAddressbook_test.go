@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAddressbookCSV(t *testing.T) {
+	path := filepath.Join("testdata", "addressbook", "contacts.csv")
+	addressbook, err := parseAddressbookCSV(path, "Full Name", "EMAIL")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"nobody@anonymous.com": "Nobody From CSV",
+		"foo@home.com":         "Home, Foo",
+		"csv@example.com":      "Only In CSV",
+	}, addressbook)
+
+	_, err = parseAddressbookCSV(path, "name", "email")
+	assert.Error(t, err)
+}
+
+func TestParseAddressbook(t *testing.T) {
+	addressbook := parseAddressbook([]addressbookSource{
+		{Name: "csv", Type: "csv", Path: filepath.Join("testdata", "addressbook", "contacts.csv"), NameColumn: "full name", EmailColumn: "email"},
+		{Name: "khard", Type: "vcard", Path: filepath.Join("testdata", "addressbook", "vdir")},
+	})
+	assert.Equal(t, addressbookEntry{"Home, Foo", "csv"}, addressbook["foo@home.com"])
+	assert.Equal(t, addressbookEntry{"Foo Bar, the Example", "khard"}, addressbook["foo@bar.com"])
+	assert.Nil(t, parseAddressbook(nil))
+}
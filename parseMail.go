@@ -4,13 +4,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"mime"
+	netmail "net/mail"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode/utf8"
 
 	"github.com/emersion/go-mbox"
@@ -19,13 +24,42 @@ import (
 	"github.com/emersion/go-message/mail"
 )
 
-func mboxParser(path string, headers chan<- *mail.Header) error {
-	f, err := os.Open(path)
-	defer f.Close()
+// messageFile is a file queued for parsing. Parsing of mbox files stops at
+// size, the size the file had when it was listed, so that messages appended
+// meanwhile are left for the next run. For mbox files that were appended to
+// since the last run, parsing resumes at offset.
+type messageFile struct {
+	path   string
+	offset int64
+	size   int64
+}
+
+// envelope is a parsed message header along with the file it was read from
+// and the time that file was last modified. If the file could not be parsed,
+// err is set instead of header.
+type envelope struct {
+	path    string
+	mbox    bool
+	modTime time.Time
+	header  *mail.Header
+	err     error
+}
+
+func mboxParser(file messageFile, headers chan<- envelope) error {
+	f, err := os.Open(file.path)
 	if err != nil {
 		return err
 	}
-	mbr := mbox.NewReader(f)
+	defer f.Close()
+	var modTime time.Time
+	if info, err := f.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+	var r io.Reader = f
+	if file.size > 0 {
+		r = io.NewSectionReader(f, file.offset, file.size-file.offset)
+	}
+	mbr := mbox.NewReader(r)
 	for {
 		msg, err := mbr.NextMessage()
 		if errors.Is(err, io.EOF) {
@@ -35,12 +69,12 @@ func mboxParser(path string, headers chan<- *mail.Header) error {
 		}
 		entity, err := message.Read(msg)
 		h := &mail.Header{Header: entity.Header}
-		headers <- h
+		headers <- envelope{path: file.path, mbox: true, modTime: modTime, header: h}
 	}
 	return nil
 }
 
-func emlParser(path string, headers chan<- *mail.Header) error {
+func emlParser(path string, headers chan<- envelope) error {
 	f, err := os.Open(path)
 	defer f.Close()
 	if err != nil {
@@ -51,52 +85,92 @@ func emlParser(path string, headers chan<- *mail.Header) error {
 	if err != nil {
 		return err
 	}
+	var modTime time.Time
+	if info, err := f.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
 	h := &mail.Header{Header: r.Header.Header}
-	headers <- h
+	headers <- envelope{path: path, modTime: modTime, header: h}
 	return nil
 }
 
 func messageParser(
-	paths chan string,
-	headers chan<- *mail.Header,
+	files <-chan messageFile,
+	headers chan<- envelope,
 ) {
-	for path := range paths {
-		err := emlParser(path, headers)
+	for file := range files {
+		if file.offset > 0 {
+			if err := mboxParser(file, headers); err != nil {
+				fmt.Fprintln(os.Stderr, file.path, err)
+				headers <- envelope{path: file.path, mbox: true, err: err}
+			}
+			continue
+		}
+		err := emlParser(file.path, headers)
 		if err != nil {
-			mboxerr := mboxParser(path, headers)
+			mboxerr := mboxParser(file, headers)
 			if mboxerr == nil {
 				// do nothing
 			} else if utf8.ValidString(err.Error()) {
-				fmt.Fprintln(os.Stderr, path, err)
+				fmt.Fprintln(os.Stderr, file.path, err)
 			} else {
-				fmt.Fprintln(os.Stderr, path, "mail reader error, probably tried reading binary")
+				fmt.Fprintln(os.Stderr, file.path, "mail reader error, probably tried reading binary")
+			}
+			if mboxerr != nil {
+				headers <- envelope{path: file.path, err: mboxerr}
 			}
 		}
 	}
 }
 
+// addressHeaders are the headers addresses are read from by default.
+var addressHeaders = []string{
+	"to",
+	"cc",
+	"bcc",
+	"from",
+	"sender",
+	"reply-to",
+	"resent-to",
+	"resent-cc",
+	"resent-bcc",
+	"resent-from",
+	"resent-sender",
+	"mail-followup-to",
+}
+
+// dedupHeaders mostly repeat addresses of the other headers, a reply to a
+// list has the list both in To and Mail-Followup-To. They only count the
+// addresses not already found in one of the other headers of a message.
+var dedupHeaders = []string{
+	"resent-to",
+	"resent-cc",
+	"resent-bcc",
+	"resent-from",
+	"resent-sender",
+	"mail-followup-to",
+}
+
+// senderHeader is the header with the sender of the recipients in field:
+// whoever resent a message is the sender of the Resent-* recipients.
+func senderHeader(field string) string {
+	if strings.HasPrefix(field, "resent-") {
+		return "resent-from"
+	}
+	return "from"
+}
+
+// assignClass returns the class of an address in field of a message from
+// sender with the default classes.
 func assignClass(
 	field string,
 	sender string,
 	useraddresses []*regexp.Regexp,
 ) int {
-	if len(useraddresses) == 0 {
-		return 2
-	}
-	if field == "from" {
-		return 0
-	}
-	for _, addr := range useraddresses {
-		if addr.MatchString(sender) {
-			switch field {
-			case "to", "bcc":
-				return 2
-			case "cc":
-				return 1
-			}
-		}
-	}
-	return 0
+	return defaultClassRules.assign(
+		classContext{field: field, sender: sender},
+		useraddresses,
+	)
 }
 
 func filterAddress(
@@ -132,16 +206,195 @@ func filterAddress(
 	return false
 }
 
+// anyAddress stands in for the user's addresses in sources of sent mail.
+var anyAddress = regexp.MustCompile("")
+
+// parseOptions are the settings which influence how addresses are extracted
+// from a message.
+type parseOptions struct {
+	useraddresses []*regexp.Regexp
+	customFilters []*regexp.Regexp
+	halfLife      time.Duration
+	// excludeTags are the notmuch tags of messages which are left out.
+	excludeTags []string
+
+	// since and until limit the messages read to those sent in between.
+	// If mtimeFilter is set, files last modified before since are not even
+	// opened when there is no cache.
+	since       time.Time
+	until       time.Time
+	mtimeFilter bool
+
+	// headers are the headers addresses are read from, addressHeaders if
+	// empty.
+	headers []string
+
+	// automated maps the automated signals to their policy, signals
+	// which are not in it are ignored.
+	automated map[string]string
+
+	// canonicalize are the canonicalRules applied to addresses.
+	canonicalize []string
+
+	// classes are the rules addresses are classified by,
+	// defaultClassRules if empty.
+	classes classRules
+
+	// dateFallback lists where the date of a message without a usable Date
+	// header is taken from, see envelopeDate. futureDates decides what
+	// happens to dates too far in the future.
+	dateFallback []string
+	futureDates  string
+
+	// The rest is set for each source by mailSource.parseOptions.
+	label  string
+	sent   bool
+	weight int
+}
+
+// inWindow checks whether date lies between since and until.
+func (opts parseOptions) inWindow(date time.Time) bool {
+	if !opts.since.IsZero() && date.Before(opts.since) {
+		return false
+	}
+	return opts.until.IsZero() || date.Before(opts.until)
+}
+
+// hasWindow tells whether messages are limited by date at all.
+func (opts parseOptions) hasWindow() bool {
+	return !opts.since.IsZero() || !opts.until.IsZero()
+}
+
+// futureDateTolerance is how far in the future a date may be before it is
+// considered wrong, which leaves room for clocks which are a bit off.
+const futureDateTolerance = 24 * time.Hour
+
+// dateFallbacks are the places the date of a message can be taken from if
+// its Date header is missing or broken, in their default order.
+var dateFallbacks = []string{"received", "filename", "mtime"}
+
+// isFutureDate checks whether date is too far in the future to be right.
+func isFutureDate(date time.Time) bool {
+	return date.After(time.Now().Add(futureDateTolerance))
+}
+
+// receivedDate is the newest of the timestamps the servers a message passed
+// through added to its Received headers, after the last semicolon.
+func receivedDate(h *mail.Header) (time.Time, bool) {
+	var newest time.Time
+	for _, received := range h.Values("received") {
+		i := strings.LastIndex(received, ";")
+		if i < 0 {
+			continue
+		}
+		date, err := netmail.ParseDate(strings.TrimSpace(received[i+1:]))
+		if err != nil || isFutureDate(date) {
+			continue
+		}
+		if date.After(newest) {
+			newest = date
+		}
+	}
+	return newest, !newest.IsZero()
+}
+
+// maildirFilenamePattern matches the delivery time at the start of the name
+// maildir gives to a message, as in 1736000000.M42P4242.host:2,S.
+var maildirFilenamePattern = regexp.MustCompile(`^([0-9]{9,})\.`)
+
+// filenameDate is the time a message was delivered to a maildir, as recorded
+// in its file name.
+func filenameDate(e envelope) (time.Time, bool) {
+	if e.mbox || strings.HasPrefix(e.path, notmuchPrefix) {
+		return time.Time{}, false
+	}
+	match := maildirFilenamePattern.FindStringSubmatch(filepath.Base(e.path))
+	if match == nil {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	date := time.Unix(seconds, 0)
+	return date, !isFutureDate(date)
+}
+
+// envelopeDate is the date a message was sent. If the Date header is missing
+// or broken, the date is taken from the first of opts.dateFallback which
+// knows it:
+//
+//   - received: the newest timestamp of the Received headers
+//   - filename: the delivery time in the name of a maildir file
+//   - mtime: the time the file was last modified
+//
+// Dates too far in the future are treated as broken with futureDates set to
+// fallback (the default), replaced by the current time with clamp and kept
+// as they are with keep. A message without any date gets the zero time, as
+// it always did.
+func envelopeDate(e envelope, opts parseOptions) (time.Time, error) {
+	date, err := e.header.Date()
+	missing := err == nil && date.IsZero()
+	if err == nil && isFutureDate(date) {
+		switch opts.futureDates {
+		case "keep":
+			return date, nil
+		case "clamp":
+			return time.Now(), nil
+		default:
+			err = fmt.Errorf("date in the future: %s", date)
+		}
+	}
+	if err == nil && !missing {
+		return date, nil
+	}
+	for _, fallback := range opts.dateFallback {
+		var date time.Time
+		ok := false
+		switch fallback {
+		case "received":
+			date, ok = receivedDate(e.header)
+		case "filename":
+			date, ok = filenameDate(e)
+		case "mtime":
+			date, ok = e.modTime, !e.modTime.IsZero() && !isFutureDate(e.modTime)
+		}
+		if ok {
+			return date, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// addForm counts a form of the address of aD if addresses are
+// canonicalized.
+func addForm(aD *AddressData, form string, written bool, opts parseOptions) {
+	if len(opts.canonicalize) == 0 {
+		return
+	}
+	if aD.Forms == nil {
+		aD.Forms = make(map[string]addressForm)
+	}
+	count := aD.Forms[form]
+	count.Seen += max(opts.weight, 1)
+	if written {
+		count.Written += max(opts.weight, 1)
+	}
+	aD.Forms[form] = count
+}
+
 func processEnvelope(
 	envelope *mail.Header,
+	date time.Time,
 	addressmap map[string]AddressData,
-	useraddresses []*regexp.Regexp,
-	customFilters []*regexp.Regexp,
+	opts parseOptions,
 ) error {
-	addressheaders := [6]string{"to", "cc", "bcc", "from", "sender", "reply-to"}
-	time, err := envelope.Date()
-	if err != nil {
-		return err
+	addressheaders := opts.headers
+	if len(addressheaders) == 0 {
+		addressheaders = addressHeaders
+	}
+	if !opts.inWindow(date) {
+		return nil
 	}
 
 	listidheader := envelope.Get("list-id")
@@ -155,65 +408,141 @@ func processEnvelope(
 	)
 	listid := listidpattern.ReplaceAllString(listidheader, "$2")
 
+	useraddresses := opts.useraddresses
+	if opts.sent {
+		useraddresses = []*regexp.Regexp{anyAddress}
+	}
+	weight := max(opts.weight, 1)
+	decay := addDecay(0, false, date.Unix(), opts.halfLife) + math.Log2(float64(weight))
+
 	senderaddress, err := envelope.AddressList("from")
 	if err != nil {
 		return err
 	}
-	var sender string
+	senders := make(map[string]string)
 
 	if len(senderaddress) > 0 {
-		sender = strings.ToLower(senderaddress[0].Address)
+		senders["from"] = strings.ToLower(senderaddress[0].Address)
 	} else {
-		sender = ""
+		senders["from"] = ""
+	}
+	if resentaddress, err := envelope.AddressList("resent-from"); err == nil && len(resentaddress) > 0 {
+		senders["resent-from"] = strings.ToLower(resentaddress[0].Address)
+	}
+	classes := opts.classes.count()
+	ctx := classContext{
+		list:  listidheader != "",
+		reply: envelope.Get("in-reply-to") != "",
+	}
+	for _, field := range recipientHeaders {
+		recipients, err := envelope.AddressList(field)
+		if err != nil {
+			continue
+		}
+		for _, recipient := range recipients {
+			if isUserAddress(strings.ToLower(recipient.Address), useraddresses) {
+				ctx.toMe = true
+			}
+		}
 	}
 
+	policy := automatedPolicy(envelope, opts.automated)
+
+	fields := make([]string, 0, len(addressheaders))
+	for _, field := range addressheaders {
+		if !slices.Contains(dedupHeaders, field) {
+			fields = append(fields, field)
+		}
+	}
 	for _, field := range addressheaders {
+		if slices.Contains(dedupHeaders, field) {
+			fields = append(fields, field)
+		}
+	}
+	seen := make(map[string]bool)
+
+	for _, field := range fields {
+		dedup := slices.Contains(dedupHeaders, field)
 		header, err := envelope.AddressList(field)
 		if err != nil {
 			continue
 		}
+		automated := policy != "" && slices.Contains(automatedFields, field)
+		if automated && policy == "drop" {
+			continue
+		}
 		for _, address := range header {
-			normaddr := strings.ToLower(address.Address)
-			if filterAddress(normaddr, customFilters) {
+			form := strings.ToLower(address.Address)
+			if filterAddress(form, opts.customFilters) {
 				continue
 			}
-			class := assignClass(
-				field,
-				sender,
-				useraddresses,
-			)
+			normaddr := canonicalAddress(form, opts.canonicalize)
+			ctx.field, ctx.sender = field, senders[senderHeader(field)]
+			class := opts.classes.assign(ctx, useraddresses)
+			if automated && policy == "demote" {
+				class = 0
+			}
 			dec := new(mime.WordDecoder)
 			name, err := dec.DecodeHeader(address.Name)
 			if err != nil {
 				continue
 			}
+			if dedup && seen[normaddr] {
+				continue
+			}
+			seen[normaddr] = true
+			written := slices.Contains(recipientHeaders, field) && isUserAddress(ctx.sender, useraddresses)
+			provenance := nameByOthers
+			if written {
+				provenance = nameByMe
+			} else if slices.Contains(selfNameFields, field) {
+				provenance = nameBySelf
+			}
 			if addressdata, ok := addressmap[normaddr]; ok {
-				if (strings.ToLower(name) != normaddr) && (strings.ToLower(name) != "") {
+				if (strings.ToLower(name) != form) && (strings.ToLower(name) != "") {
 					addressdata.Names = append(addressdata.Names, name)
+					addNameUse(&addressdata, name, provenance, date.Unix(), weight, decay)
 				}
 				if addressdata.Class < class {
 					addressdata.Class = class
 				}
-				if addressdata.ClassDate[class] < time.Unix() {
-					addressdata.ClassDate[class] = time.Unix()
+				growClasses(&addressdata, classes)
+				if addressdata.ClassDate[class] < date.Unix() {
+					addressdata.ClassDate[class] = date.Unix()
+				}
+				addressdata.ClassDecay[class] = mergeDecay(
+					addressdata.ClassDecay[class],
+					addressdata.ClassCount[class] > 0,
+					decay,
+				)
+				addressdata.ClassCount[class] += weight
+				addressdata.IsAutomated = addressdata.IsAutomated || automated
+				addForm(&addressdata, form, written, opts)
+				if opts.label != "" && !slices.Contains(addressdata.Sources, opts.label) {
+					addressdata.Sources = append(addressdata.Sources, opts.label)
 				}
-				addressdata.ClassCount[class]++
 				addressmap[normaddr] = addressdata
 			} else {
 				addressdata := AddressData{}
-				if (strings.ToLower(name) != normaddr) && (strings.ToLower(name) != "") {
+				if (strings.ToLower(name) != form) && (strings.ToLower(name) != "") {
 					addressdata.Names = append(addressdata.Names, name)
+					addNameUse(&addressdata, name, provenance, date.Unix(), weight, decay)
 				}
-				if len(listid) > 0 && (strings.Join(strings.Split(normaddr, "@"), ".") == listid) {
+				if len(listid) > 0 && (strings.Join(strings.Split(form, "@"), ".") == listid) {
 					addressdata.ListName = listname
 					addressdata.ListId = listid
 				}
 				addressdata.Address = normaddr
 				addressdata.Class = class
-				addressdata.ClassDate = [3]int64{0, 0, 0}
-				addressdata.ClassDate[class] = time.Unix()
-				addressdata.ClassCount = [3]int{0, 0, 0}
-				addressdata.ClassCount[class] = 1
+				growClasses(&addressdata, classes)
+				addressdata.ClassDate[class] = date.Unix()
+				addressdata.ClassCount[class] = weight
+				addressdata.ClassDecay[class] = decay
+				addressdata.IsAutomated = automated
+				addForm(&addressdata, form, written, opts)
+				if opts.label != "" {
+					addressdata.Sources = []string{opts.label}
+				}
 				addressmap[normaddr] = addressdata
 			}
 		}
@@ -222,41 +551,74 @@ func processEnvelope(
 	return nil
 }
 
+// processEnvelopeChan collects the addresses of all envelopes. If perFile is
+// set, the addresses are collected separately for every file, so that they
+// can be cached, otherwise everything ends up under the empty path and the
+// number of parsed messages is printed.
 func processEnvelopeChan(
-	envelopechan <-chan *mail.Header,
-	retvalchan chan map[string]AddressData,
-	useraddresses []*regexp.Regexp,
-	customFilters []*regexp.Regexp,
+	envelopechan <-chan envelope,
+	retvalchan chan map[string]*fileContribution,
+	perFile bool,
+	opts parseOptions,
 ) {
 	count := 0
 	errcount := 0
-	addressmap := make(map[string]AddressData)
+	contributions := make(map[string]*fileContribution)
 	for envelope := range envelopechan {
-		err := processEnvelope(
-			envelope,
-			addressmap,
-			useraddresses,
-			customFilters,
-		)
+		key := ""
+		if perFile {
+			key = envelope.path
+		}
+		contribution, ok := contributions[key]
+		if !ok {
+			contribution = &fileContribution{
+				Addresses: make(map[string]AddressData),
+				Threads:   newThreadIndex(),
+			}
+			contributions[key] = contribution
+		}
+		contribution.Mbox = envelope.mbox
+		err := envelope.err
 		if err != nil {
+			contribution.failed = true
+		} else {
+			var date time.Time
+			date, err = envelopeDate(envelope, opts)
+			if err == nil {
+				contribution.addDate(date.Unix())
+				contribution.Threads.add(envelope.header, date, opts)
+				err = processEnvelope(
+					envelope.header,
+					date,
+					contribution.Addresses,
+					opts,
+				)
+			}
+		}
+		if err != nil {
+			contribution.errors++
 			errcount++
 		} else {
+			contribution.parsed++
 			count++
 		}
 
 	}
-	fmt.Println("Read", count+errcount, "files of which", count, "could be parsed.")
-	retvalchan <- addressmap
+	if !perFile {
+		fmt.Println("Read", count+errcount, "files of which", count, "could be parsed.")
+	}
+	retvalchan <- contributions
 	close(retvalchan)
 }
 
-func walkMaildir(
-	path string,
-	useraddresses []*regexp.Regexp,
-	customFilters []*regexp.Regexp,
-) map[string]AddressData {
-	envelopechan := make(chan *mail.Header)
-	messagePaths := make(chan string, 4096)
+// parseMessages parses every file sent on messageFiles with a pool of workers
+// and delivers the collected addresses once messageFiles is closed.
+func parseMessages(
+	messageFiles <-chan messageFile,
+	perFile bool,
+	opts parseOptions,
+) <-chan map[string]*fileContribution {
+	envelopechan := make(chan envelope)
 
 	var wg sync.WaitGroup
 	for i := 0; i < 2*runtime.NumCPU(); i++ {
@@ -264,18 +626,35 @@ func walkMaildir(
 		go func() {
 			defer wg.Done()
 
-			messageParser(messagePaths, envelopechan)
+			messageParser(messageFiles, envelopechan)
 		}()
 	}
 
-	retvalchan := make(chan map[string]AddressData)
-	go processEnvelopeChan(envelopechan, retvalchan, useraddresses, customFilters)
+	retvalchan := make(chan map[string]*fileContribution)
+	go processEnvelopeChan(envelopechan, retvalchan, perFile, opts)
+	go func() {
+		wg.Wait()
+		close(envelopechan)
+	}()
+	return retvalchan
+}
 
-	filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+// walkMessageFiles calls fn for every file of source which might hold mail,
+// skipping hidden files, the contents of tmp and .notmuch folders and
+// whatever the include and exclude patterns of source leave out.
+func walkMessageFiles(source mailSource, fn func(path string, info os.FileInfo)) error {
+	root := source.Path
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		rel, _ := filepath.Rel(root, path)
 		if strings.HasPrefix(filepath.Base(path), ".") {
+			// Hidden folders are still walked, as maildir++ keeps its
+			// folders in them.
+			if info.IsDir() && path != root && source.excluded(rel) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -284,15 +663,106 @@ func walkMaildir(
 			case "tmp", ".notmuch":
 				return filepath.SkipDir
 			}
+			if path != root && source.excluded(rel) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-		messagePaths <- path
+		if path == root {
+			rel = filepath.Base(path)
+		}
+		if !source.included(rel) {
+			return nil
+		}
+		fn(path, info)
 		return nil
 	})
-	close(messagePaths)
+}
 
-	wg.Wait()
-	close(envelopechan)
+// walkMaildir collects the addresses of the messages in source. The message
+// ids and replies of all of them, whether within the window or not, are
+// recorded in threads.
+func walkMaildir(
+	source mailSource,
+	opts parseOptions,
+	cache *addressCache,
+	threads *threadIndex,
+) map[string]AddressData {
+	// The cache holds every message of a file, the window is applied when
+	// the cached contributions are used.
+	parseOpts := opts
+	if cache != nil {
+		parseOpts.since, parseOpts.until = time.Time{}, time.Time{}
+	}
+	messageFiles := make(chan messageFile, 4096)
+	retvalchan := parseMessages(messageFiles, cache != nil, parseOpts)
+
+	data := make(map[string]AddressData)
+	partial := []string{}
+	use := func(path string, contribution *fileContribution) {
+		threads.merge(contribution.Threads)
+		switch contribution.inWindow(opts) {
+		case windowAll:
+			data = mergeSources(data, contribution.Addresses)
+		case windowPart:
+			partial = append(partial, path)
+		}
+	}
+	type changedFile struct {
+		info     os.FileInfo
+		previous *fileContribution
+	}
+	changed := make(map[string]changedFile)
+	cached := 0
+	walkMessageFiles(source, func(path string, info os.FileInfo) {
+		file := messageFile{path: path, size: info.Size()}
+		if cache != nil {
+			contribution, fresh := cache.lookup(source.Path, path, info)
+			if fresh {
+				use(path, contribution)
+				cached++
+				return
+			}
+			if contribution != nil {
+				file.offset = contribution.Offset
+			}
+			changed[path] = changedFile{info, contribution}
+		} else if opts.mtimeFilter && !opts.since.IsZero() && info.ModTime().Before(opts.since) {
+			return
+		}
+		messageFiles <- file
+	})
+	close(messageFiles)
 
-	return <-retvalchan
+	contributions := <-retvalchan
+	if cache == nil {
+		if contribution, ok := contributions[""]; ok {
+			threads.merge(contribution.Threads)
+			return contribution.Addresses
+		}
+		return data
+	}
+	count, errcount := 0, 0
+	for path, file := range changed {
+		parsed := contributions[path]
+		if parsed != nil {
+			count += parsed.parsed
+			errcount += parsed.errors
+		}
+		use(path, cache.store(source.Path, path, file.info, file.previous, parsed))
+	}
+	fmt.Println("Read", count+errcount, "files of which", count, "could be parsed,", cached, "unchanged files were taken from the cache.")
+	if len(partial) > 0 {
+		// Only some messages of these files are in the window, they have to
+		// be read again.
+		messageFiles := make(chan messageFile, len(partial))
+		for _, path := range partial {
+			messageFiles <- messageFile{path: path}
+		}
+		close(messageFiles)
+		if contribution, ok := (<-parseMessages(messageFiles, false, opts))[""]; ok {
+			data = mergeSources(data, contribution.Addresses)
+		}
+	}
+	return data
 }
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
+	"time"
 
+	"github.com/emersion/go-message/mail"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,6 +31,11 @@ func TestAssignClass(t *testing.T) {
 		{"Explicit matching in cc", "cc", "foo@bar.com", useraddresses, 1},
 		{"Regex match in to", "to", "name-foo@example.com", useraddresses, 2},
 		{"No matches", "to", "something@example.com", useraddresses, 0},
+		{"Resent to", "resent-to", "foo@bar.com", useraddresses, 2},
+		{"Resent cc", "resent-cc", "foo@bar.com", useraddresses, 1},
+		{"Resent from", "resent-from", "foo@bar.com", useraddresses, 0},
+		{"Mail-Followup-To", "mail-followup-to", "foo@bar.com", useraddresses, 2},
+		{"Mail-Followup-To not from me", "mail-followup-to", "something@example.com", useraddresses, 0},
 	}
 
 	for _, tt := range tests {
@@ -35,3 +45,143 @@ func TestAssignClass(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvelopeDate(t *testing.T) {
+	date := time.Date(2025, 1, 7, 10, 0, 0, 0, time.UTC)
+	received := time.Date(2025, 1, 7, 10, 5, 0, 0, time.UTC)
+	delivered := time.Date(2025, 1, 7, 10, 6, 0, 0, time.UTC)
+	modTime := time.Date(2025, 1, 8, 0, 0, 0, 0, time.UTC)
+	future := time.Now().AddDate(10, 0, 0)
+	header := func(fields ...string) *mail.Header {
+		h := &mail.Header{}
+		for i := 0; i < len(fields); i += 2 {
+			h.Add(fields[i], fields[i+1])
+		}
+		return h
+	}
+	receivedHeaders := []string{
+		"Received", "from a by b; " + received.Add(-time.Minute).Format(time.RFC1123Z),
+		"Received", "from c by d; " + received.Format(time.RFC1123Z),
+		"Received", "from e by f; garbage",
+	}
+	path := fmt.Sprintf("cur/%d.M1P2.host:2,S", delivered.Unix())
+
+	tests := []struct {
+		testname     string
+		envelope     envelope
+		dateFallback []string
+		futureDates  string
+		want         time.Time
+		wantErr      bool
+	}{
+		{
+			"Date header",
+			envelope{path: path, modTime: modTime, header: header(append(receivedHeaders, "Date", date.Format(time.RFC1123Z))...)},
+			dateFallbacks, "fallback", date, false,
+		},
+		{
+			"Received",
+			envelope{path: path, modTime: modTime, header: header(receivedHeaders...)},
+			dateFallbacks, "fallback", received, false,
+		},
+		{
+			"Broken date uses received",
+			envelope{path: path, modTime: modTime, header: header(append(receivedHeaders, "Date", "yesterday")...)},
+			dateFallbacks, "fallback", received, false,
+		},
+		{
+			"Filename",
+			envelope{path: path, modTime: modTime, header: header()},
+			dateFallbacks, "fallback", delivered, false,
+		},
+		{
+			"No filename in mbox",
+			envelope{path: path, mbox: true, modTime: modTime, header: header()},
+			dateFallbacks, "fallback", modTime, false,
+		},
+		{
+			"Mtime",
+			envelope{path: "cur/message", modTime: modTime, header: header()},
+			dateFallbacks, "fallback", modTime, false,
+		},
+		{
+			"Order of fallbacks",
+			envelope{path: path, modTime: modTime, header: header(receivedHeaders...)},
+			[]string{"mtime", "received"},
+			"fallback", modTime, false,
+		},
+		{
+			"No fallbacks",
+			envelope{path: path, modTime: modTime, header: header(append(receivedHeaders, "Date", "yesterday")...)},
+			nil, "fallback",
+			time.Time{},
+			true,
+		},
+		{
+			"Nothing to fall back to",
+			envelope{path: "notmuch:id", header: header()},
+			dateFallbacks, "fallback",
+			time.Time{},
+			false,
+		},
+		{
+			"Future date falls back",
+			envelope{path: path, modTime: modTime, header: header(append(receivedHeaders, "Date", future.Format(time.RFC1123Z))...)},
+			dateFallbacks, "fallback", received, false,
+		},
+		{
+			"Future date is kept",
+			envelope{path: path, modTime: modTime, header: header("Date", future.Format(time.RFC1123Z))},
+			dateFallbacks, "keep", future.Truncate(time.Second), false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, err := envelopeDate(tt.envelope, parseOptions{dateFallback: tt.dateFallback, futureDates: tt.futureDates})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, tt.want.Equal(got), "got %v, want %v", got, tt.want)
+		})
+	}
+
+	clamped, err := envelopeDate(
+		envelope{header: header("Date", future.Format(time.RFC1123Z))},
+		parseOptions{futureDates: "clamp"},
+	)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), clamped, time.Minute)
+}
+
+func TestMboxParserStopsAtSize(t *testing.T) {
+	mboxpath := filepath.Join(copyTestdata(t, "./testdata/endtoend"), "samplembox.mbox")
+	count := func(file messageFile) int {
+		headers := make(chan envelope, 100)
+		assert.NoError(t, mboxParser(file, headers))
+		close(headers)
+		return len(headers)
+	}
+	info, err := os.Stat(mboxpath)
+	assert.NoError(t, err)
+	before := count(messageFile{path: mboxpath, size: info.Size()})
+	assert.Greater(t, before, 0)
+
+	// a message delivered after the file was listed
+	f, err := os.OpenFile(mboxpath, os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.NoError(t, err)
+	f.WriteString("From mboxrd@z Thu Jan  1 00:00:00 1970\n" +
+		"From: Appended <appended@example.com>\n" +
+		"Date: Sat, 18 Jan 2025 00:42:04 +0000\n" +
+		"\n" +
+		"appended message\n")
+	f.Close()
+	assert.Equal(t, before, count(messageFile{path: mboxpath, size: info.Size()}))
+
+	appended, err := os.Stat(mboxpath)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count(messageFile{path: mboxpath, offset: info.Size(), size: appended.Size()}))
+	assert.Equal(t, before+1, count(messageFile{path: mboxpath}))
+}
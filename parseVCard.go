@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/quotedprintable"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// vcardError is a problem found while parsing a vCard file.
+type vcardError struct {
+	path string
+	line int
+	msg  string
+}
+
+func (e vcardError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.path, e.line, e.msg)
+}
+
+// vcardContact is the part of a vCard we are interested in.
+type vcardContact struct {
+	name   string
+	emails []string
+}
+
+// vcardUnescape reverses the escaping of text values.
+func vcardUnescape(s string) string {
+	return strings.NewReplacer(
+		`\\`, `\`,
+		`\,`, ",",
+		`\;`, ";",
+		`\n`, "\n",
+		`\N`, "\n",
+	).Replace(s)
+}
+
+// vcardProperty splits an unfolded content line into its upper cased name
+// (without group), its parameters and its value.
+func vcardProperty(line string) (name string, params []string, value string, ok bool) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return "", nil, "", false
+	}
+	params = strings.Split(line[:colon], ";")
+	name = strings.ToUpper(params[0])
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+	value = line[colon+1:]
+	for _, param := range params[1:] {
+		if strings.EqualFold(param, "ENCODING=QUOTED-PRINTABLE") {
+			decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(value)))
+			if err == nil {
+				value = string(decoded)
+			}
+		}
+	}
+	return name, params[1:], value, true
+}
+
+// readVCards reads every vCard from r. Problems are reported with the line
+// they were found on, the contacts parsed so far are returned regardless.
+func readVCards(path string, r io.Reader) ([]vcardContact, []error) {
+	contacts := []vcardContact{}
+	errs := []error{}
+	var contact *vcardContact
+	var structuredName string
+	begin := 0
+
+	handle := func(line string, lineno int) {
+		if line == "" {
+			return
+		}
+		name, _, value, ok := vcardProperty(line)
+		if !ok {
+			errs = append(errs, vcardError{path, lineno, "missing colon in " + line})
+			return
+		}
+		switch name {
+		case "BEGIN":
+			if contact != nil {
+				errs = append(errs, vcardError{path, begin, "vCard is not terminated by END:VCARD"})
+			}
+			contact = &vcardContact{}
+			structuredName = ""
+			begin = lineno
+		case "END":
+			if contact == nil {
+				errs = append(errs, vcardError{path, lineno, "END:VCARD without BEGIN:VCARD"})
+				return
+			}
+			if contact.name == "" {
+				contact.name = structuredName
+			}
+			contacts = append(contacts, *contact)
+			contact = nil
+		default:
+			if contact == nil {
+				errs = append(errs, vcardError{path, lineno, "property outside of a vCard: " + name})
+				return
+			}
+			switch name {
+			case "FN":
+				contact.name = strings.TrimSpace(vcardUnescape(value))
+			case "N":
+				// Family;Given;Additional;Prefixes;Suffixes
+				parts := strings.Split(value, ";")
+				if len(parts) > 1 {
+					structuredName = strings.TrimSpace(vcardUnescape(parts[1]) + " " + vcardUnescape(parts[0]))
+				}
+			case "EMAIL":
+				email := strings.TrimSpace(vcardUnescape(value))
+				email = strings.TrimPrefix(strings.TrimPrefix(email, "mailto:"), "MAILTO:")
+				if email != "" {
+					contact.emails = append(contact.emails, strings.ToLower(email))
+				}
+			}
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var logical strings.Builder
+	logicalStart := 0
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			logical.WriteString(line[1:])
+			continue
+		}
+		handle(logical.String(), logicalStart)
+		logical.Reset()
+		logical.WriteString(line)
+		logicalStart = lineno
+	}
+	handle(logical.String(), logicalStart)
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, vcardError{path, lineno, err.Error()})
+	}
+	if contact != nil {
+		errs = append(errs, vcardError{path, begin, "vCard is not terminated by END:VCARD"})
+	}
+	return contacts, errs
+}
+
+// vcardFiles lists path if it is a file, or every .vcf file below it if it is
+// a directory, such as a vdir.
+func vcardFiles(path string) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != path && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && (p == path || strings.EqualFold(filepath.Ext(p), ".vcf")) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// parseVCardAddressbook maps every email address of every contact found in
+// the vCard file or vdir directory at path to the contact's name.
+func parseVCardAddressbook(
+	path string,
+) map[string]string {
+	addressbook := make(map[string]string)
+	files, err := vcardFiles(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		contacts, errs := readVCards(file, f)
+		f.Close()
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		for _, contact := range contacts {
+			for _, email := range contact.emails {
+				if _, ok := addressbook[email]; !ok {
+					addressbook[email] = contact.name
+				}
+			}
+		}
+	}
+	return addressbook
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadVCards(t *testing.T) {
+	path := filepath.Join("testdata", "addressbook", "vdir", "foo.vcf")
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	contacts, errs := readVCards(path, f)
+	assert.Empty(t, errs)
+	assert.Equal(t, []vcardContact{{
+		name:   "Foo Bar, the Example",
+		emails: []string{"foo@bar.com", "foo.bar@example.com", "foo@home.com"},
+	}}, contacts)
+}
+
+func TestReadVCardsErrors(t *testing.T) {
+	path := filepath.Join("testdata", "addressbook", "vdir", "broken.vcf")
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	contacts, errs := readVCards(path, f)
+	assert.Len(t, contacts, 1)
+	assert.Equal(t, []error{
+		vcardError{path, 4, "missing colon in this line has no colon"},
+		vcardError{path, 7, "END:VCARD without BEGIN:VCARD"},
+		vcardError{path, 8, "vCard is not terminated by END:VCARD"},
+	}, errs)
+	assert.Equal(t, path+":4: missing colon in this line has no colon", errs[0].Error())
+}
+
+func TestParseVCardAddressbook(t *testing.T) {
+	addressbook := parseVCardAddressbook(filepath.Join("testdata", "addressbook", "vdir"))
+	assert.Equal(t, map[string]string{
+		"foo@bar.com":          "Foo Bar, the Example",
+		"foo.bar@example.com":  "Foo Bar, the Example",
+		"foo@home.com":         "Foo Bar, the Example",
+		"nobody@anonymous.com": "Nobody Anonymous",
+		"arpad@example.com":    "Árpád",
+		"broken@example.com":   "Broken",
+	}, addressbook)
+}
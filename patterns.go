@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultExcludes are the folders most mail setups use for junk, which is
+// better left out of the ranking.
+var defaultExcludes = []string{
+	"Spam",
+	"Junk",
+	"Junk E-mail",
+	"Junk Email",
+	"Bulk Mail",
+	"Trash",
+	"Deleted Items",
+	"Deleted Messages",
+	"Bin",
+}
+
+// pathPattern is a gitignore style pattern matched against paths relative to
+// the root of a source:
+//
+//   - a leading ! negates the pattern
+//   - a trailing / only matches folders
+//   - a pattern without a / (other than a trailing one) matches the name of a
+//     file or folder at any depth, for folders also the last component of a
+//     maildir++ name such as .Spam or INBOX.Spam
+//   - otherwise it is matched against the whole path, a leading / is optional
+//   - * and ? do not match /, ** matches any number of folders
+//
+// Matching is case insensitive.
+type pathPattern struct {
+	negate   bool
+	dirOnly  bool
+	anchored bool
+	re       *regexp.Regexp
+}
+
+// globRegexp translates the glob syntax of a pattern into a regular
+// expression.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("(?i)^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				re.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [ in %q", glob)
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+func compilePathPattern(pattern string) (pathPattern, error) {
+	p := pathPattern{}
+	if strings.HasPrefix(pattern, "!") {
+		p.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		p.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if strings.Contains(pattern, "/") {
+		p.anchored = true
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+	if pattern == "" {
+		return p, fmt.Errorf("empty pattern")
+	}
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return p, err
+	}
+	p.re = re
+	return p, nil
+}
+
+func (p pathPattern) matches(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.anchored {
+		return p.re.MatchString(rel)
+	}
+	name := filepath.Base(rel)
+	if p.re.MatchString(name) {
+		return true
+	}
+	if i := strings.LastIndex(name, "."); isDir && i >= 0 {
+		return p.re.MatchString(name[i+1:])
+	}
+	return false
+}
+
+// pathPatterns is a list of patterns where, as in gitignore, later patterns
+// override earlier ones.
+type pathPatterns []pathPattern
+
+func compilePathPatterns(patterns []string) (pathPatterns, error) {
+	compiled := make(pathPatterns, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, err := compilePathPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+// matches checks rel and each of its parent folders against the patterns.
+// The last pattern matching the deepest of them decides, a negated one
+// meaning that rel does not match.
+func (ps pathPatterns) matches(rel string, isDir bool) bool {
+	rel = filepath.ToSlash(rel)
+	result := false
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		level := strings.Join(parts[:i+1], "/")
+		levelIsDir := isDir || i < len(parts)-1
+		for _, p := range ps {
+			if p.matches(level, levelIsDir) {
+				result = !p.negate
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathPatterns(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		rel      string
+		isDir    bool
+		want     bool
+	}{
+		{[]string{"Spam"}, "Spam", true, true},
+		{[]string{"spam"}, "work/Spam/cur/1", false, true},
+		{[]string{"Spam"}, ".Spam", true, true},
+		{[]string{"Spam"}, "INBOX.Spam/cur/1", false, true},
+		{[]string{"bak"}, "cur/1.bak", false, false},
+		{[]string{"Spam"}, "Spamalot/cur/1", false, false},
+		{[]string{"Trash/"}, "Trash", false, false},
+		{[]string{"Trash/"}, "Trash/cur/1", false, true},
+		{[]string{"/Trash"}, "work/Trash/cur/1", false, false},
+		{[]string{"work/Trash"}, "work/Trash/cur/1", false, true},
+		{[]string{"*/Trash"}, "a/b/Trash/cur/1", false, false},
+		{[]string{"**/Trash"}, "a/b/Trash/cur/1", false, true},
+		{[]string{"lists/**/new"}, "lists/a/b/new/1", false, true},
+		{[]string{"*.mbox"}, "archive/2020.mbox", false, true},
+		{[]string{"20[0-1]?"}, "2019/cur/1", false, true},
+		{[]string{"20[!0-1]?"}, "2019/cur/1", false, false},
+		{[]string{"Trash", "!Trash"}, "Trash/cur/1", false, false},
+		{[]string{"Trash", "!work/Trash"}, "work/Trash/cur/1", false, false},
+		{[]string{"Trash", "!work/Trash"}, "home/Trash/cur/1", false, true},
+		{[]string{"work", "!Trash"}, "work/Trash/cur/1", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rel, func(t *testing.T) {
+			ps, err := compilePathPatterns(tt.patterns)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, ps.matches(tt.rel, tt.isDir), "%v", tt.patterns)
+		})
+	}
+
+	for _, bad := range []string{"", "!", "/", "[abc"} {
+		_, err := compilePathPatterns([]string{bad})
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestWalkMessageFilesExcludes(t *testing.T) {
+	root := t.TempDir()
+	files := []string{
+		"INBOX/cur/1",
+		".Spam/cur/2",
+		"INBOX.Trash/cur/3",
+		"work/Junk E-mail/cur/4",
+		"work/Trash/cur/5",
+		"work/Sent/cur/6",
+		"notes.bak",
+	}
+	for _, file := range files {
+		path := filepath.Join(root, file)
+		os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		os.WriteFile(path, []byte{}, 0o644)
+	}
+	walk := func(source mailSource) []string {
+		found := []string{}
+		walkMessageFiles(source, func(path string, info os.FileInfo) {
+			rel, _ := filepath.Rel(root, path)
+			found = append(found, filepath.ToSlash(rel))
+		})
+		sort.Strings(found)
+		return found
+	}
+
+	source := mailSource{Path: root, Exclude: []string{"!work/Trash"}}
+	assert.NoError(t, source.validate(nil, slices.Concat(defaultExcludes, []string{"*.bak"})))
+	assert.Equal(t, []string{"INBOX/cur/1", "work/Sent/cur/6", "work/Trash/cur/5"}, walk(source))
+
+	source = mailSource{Path: root, Include: []string{"work"}}
+	assert.NoError(t, source.validate([]string{"cur/"}, nil))
+	assert.Equal(t, []string{"work/Junk E-mail/cur/4", "work/Sent/cur/6", "work/Trash/cur/5"}, walk(source))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// loadRankedAddresses reads the addresses written by the last run in rank
+// order. The structured sidecar written with query-data is preferred, if it
+// is missing the output itself is read assuming the default template of
+// address and name, with the scripts transliterated in NormalizedName.
+func loadRankedAddresses(path string, scripts []string) ([]AddressData, error) {
+	f, err := os.Open(sidecarPath(path))
+	if err == nil {
+		defer f.Close()
+		var ranked []AddressData
+		if err := json.NewDecoder(f).Decode(&ranked); err != nil {
+			return nil, fmt.Errorf("%s: %w", sidecarPath(path), err)
+		}
+		return ranked, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	f, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ranked := []AddressData{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		slice := strings.Split(scanner.Text(), "\t")
+		aD := AddressData{Address: slice[0]}
+		if len(slice) > 1 {
+			aD.Name = slice[1]
+			aD.NormalizedName = normalizeAddressNames(aD, scripts)
+		}
+		ranked = append(ranked, aD)
+	}
+	return ranked, scanner.Err()
+}
+
+// smartCaseContains reports whether word is found in s ignoring diacritics.
+// Case is ignored as well, unless word contains an upper case letter.
+func smartCaseContains(s string, word string) bool {
+	s = removeDiacritics(s)
+	word = removeDiacritics(word)
+	if strings.IndexFunc(word, unicode.IsUpper) < 0 {
+		s = strings.ToLower(s)
+	}
+	return strings.Contains(s, word)
+}
+
+// matchAddress reports whether every word of the query is found in either
+// the address or any of the names of aD.
+func matchAddress(aD AddressData, words []string) bool {
+	fields := append([]string{aD.Address, aD.Name, aD.NormalizedName}, aD.Names...)
+	for _, word := range words {
+		found := false
+		for _, field := range fields {
+			if smartCaseContains(field, word) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// queryAddresses returns at most limit addresses matching term, keeping
+// their rank order. A limit of 0 means no limit.
+func queryAddresses(ranked []AddressData, term string, limit int) []AddressData {
+	words := strings.Fields(term)
+	matches := []AddressData{}
+	for _, aD := range ranked {
+		if limit > 0 && len(matches) >= limit {
+			break
+		}
+		if matchAddress(aD, words) {
+			matches = append(matches, aD)
+		}
+	}
+	return matches
+}
+
+// printQueryResults prints matches as tab separated address and name lines,
+// which aerc understands. Mutt expects a status line before the results.
+func printQueryResults(w io.Writer, matches []AddressData, format string) {
+	if format == "mutt" {
+		fmt.Fprintln(w, len(matches), "matches")
+	}
+	for _, aD := range matches {
+		fmt.Fprintf(w, "%s\t%s\n", aD.Address, aD.Name)
+	}
+}
+
+func runQuery(config Config, args []string) error {
+	ranked, err := loadRankedAddresses(config.outputpath, config.transliterate)
+	if err != nil {
+		return err
+	}
+	matches := queryAddresses(ranked, strings.Join(args, " "), config.queryLimit)
+	printQueryResults(os.Stdout, matches, config.queryFormat)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryAddresses(t *testing.T) {
+	ranked := []AddressData{
+		{Address: "arpad@example.com", Name: "Árpád Kovács", NormalizedName: "Arpad Kovacs"},
+		{Address: "jane@corp.com", Name: "Jane Doe", Names: []string{"Doe, Jane", "J. Doe"}},
+		{Address: "arpad.y@example.com", Name: "Arpad Young", NormalizedName: "Arpad Young"},
+		{Address: "bob@example.com", Name: "bob"},
+	}
+
+	tests := []struct {
+		testname string
+		term     string
+		limit    int
+		want     []string
+	}{
+		{"diacritics insensitive", "arpad", 0, []string{"arpad@example.com", "arpad.y@example.com"}},
+		{"diacritics in term", "árpád", 0, []string{"arpad@example.com", "arpad.y@example.com"}},
+		{"smart case", "Bob", 0, []string{}},
+		{"lower case", "bob", 0, []string{"bob@example.com"}},
+		{"multiple words", "arpad young", 0, []string{"arpad.y@example.com"}},
+		{"alternative names", "j. doe", 0, []string{"jane@corp.com"}},
+		{"limit", "example", 2, []string{"arpad@example.com", "arpad.y@example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			addresses := []string{}
+			for _, aD := range queryAddresses(ranked, tt.term, tt.limit) {
+				addresses = append(addresses, aD.Address)
+			}
+			assert.Equal(t, tt.want, addresses)
+		})
+	}
+}
+
+func TestLoadRankedAddresses(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "addressbook.tsv")
+	data := walkSources(mailSources("./testdata/endtoend"), parseOptions{}, nil)
+	ranked := saveData(calculateRanks(data, nil, nil, rankingOptions{}), path, "template", "", template.Must(template.New("output").Parse("{{.Address}}\n")), nil, false)
+	assert.NoFileExists(t, sidecarPath(path))
+	assert.NoError(t, saveSidecar(ranked, path))
+
+	ranked, err := loadRankedAddresses(path, nil)
+	assert.NoError(t, err)
+	assert.Len(t, ranked, len(data))
+	assert.Equal(t, "ouooueau", queryAddresses(ranked, "diacritics", 0)[0].NormalizedName)
+
+	os.Remove(sidecarPath(path))
+	os.WriteFile(path, []byte("foo@bar.com\tFoo Bár\n"), 0o644)
+	ranked, err = loadRankedAddresses(path, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []AddressData{{Address: "foo@bar.com", Name: "Foo Bár", NormalizedName: "Foo Bar"}}, ranked)
+}
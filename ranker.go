@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Ranker orders the addresses within a class by setting their TotalRank,
+// lower ranks come first in the output. It is called after FrequencyRank,
+// RecencyRank, ReplyRank and FrecencyScore have been set.
+type Ranker interface {
+	Rank(addrmap map[string]AddressData, class int)
+}
+
+// newRanker returns the ranking strategy called name. The weights are only
+// used by the weighted strategy.
+func newRanker(name string, frequencyWeight float64, recencyWeight float64, replyWeight float64) (Ranker, error) {
+	switch name {
+	case "ordinal":
+		return ordinalRanker{}, nil
+	case "weighted":
+		return weightedRanker{frequency: frequencyWeight, recency: recencyWeight, reply: replyWeight}, nil
+	case "frequency":
+		return frequencyRanker{}, nil
+	case "recency":
+		return recencyRanker{}, nil
+	case "frecency":
+		return frecencyRanker{}, nil
+	}
+	return nil, fmt.Errorf("unknown ranking: %s", name)
+}
+
+// rankByScore sets the TotalRank of every address to its place in the list
+// ordered by score, lowest first. Equal scores are ordered alphabetically.
+func rankByScore(addrmap map[string]AddressData, score func(AddressData) float64) {
+	s := make([]KeyValue, 0, len(addrmap))
+	for normaddr, addrdata := range addrmap {
+		s = append(s, KeyValue{normaddr, addrdata})
+	}
+	sort.SliceStable(s, func(i, j int) bool {
+		si, sj := score(s[i].addrdata), score(s[j].addrdata)
+		if si == sj {
+			return s[i].addrdata.Address < s[j].addrdata.Address
+		} else {
+			return si < sj
+		}
+	})
+	for rank, kv := range s {
+		addrdata := addrmap[kv.normaddr]
+		addrdata.TotalRank = rank
+		addrmap[kv.normaddr] = addrdata
+	}
+}
+
+// ordinalRanker sums the frequency and recency ranks.
+type ordinalRanker struct{}
+
+func (ordinalRanker) Rank(addrmap map[string]AddressData, class int) {
+	for normaddr, addrdata := range addrmap {
+		addrdata.TotalRank = addrdata.FrequencyRank + addrdata.RecencyRank
+		addrmap[normaddr] = addrdata
+	}
+}
+
+// weightedRanker orders by the weighted sum of the frequency, recency and
+// reply ranks.
+type weightedRanker struct {
+	frequency float64
+	recency   float64
+	reply     float64
+}
+
+func (r weightedRanker) Rank(addrmap map[string]AddressData, class int) {
+	rankByScore(addrmap, func(aD AddressData) float64 {
+		return r.frequency*float64(aD.FrequencyRank) +
+			r.recency*float64(aD.RecencyRank) +
+			r.reply*float64(aD.ReplyRank)
+	})
+}
+
+// frequencyRanker only takes the number of messages into account.
+type frequencyRanker struct{}
+
+func (frequencyRanker) Rank(addrmap map[string]AddressData, class int) {
+	for normaddr, addrdata := range addrmap {
+		addrdata.TotalRank = addrdata.FrequencyRank
+		addrmap[normaddr] = addrdata
+	}
+}
+
+// recencyRanker only takes the date of the latest message into account.
+type recencyRanker struct{}
+
+func (recencyRanker) Rank(addrmap map[string]AddressData, class int) {
+	for normaddr, addrdata := range addrmap {
+		addrdata.TotalRank = addrdata.RecencyRank
+		addrmap[normaddr] = addrdata
+	}
+}
+
+// frecencyRanker orders by the sum of the exponentially decaying message
+// weights, see frecencyScore.
+type frecencyRanker struct{}
+
+func (frecencyRanker) Rank(addrmap map[string]AddressData, class int) {
+	rankByScore(addrmap, func(aD AddressData) float64 {
+		return -aD.ClassDecay[class]
+	})
+}
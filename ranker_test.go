@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRankers(t *testing.T) {
+	addrmap := map[string]AddressData{
+		"a@example.com": {Address: "a@example.com", FrequencyRank: 0, RecencyRank: 3, ClassDecay: []float64{0, 0, 1}},
+		"b@example.com": {Address: "b@example.com", FrequencyRank: 1, RecencyRank: 0, ClassDecay: []float64{0, 0, 3}},
+		"c@example.com": {Address: "c@example.com", FrequencyRank: 2, RecencyRank: 1, ClassDecay: []float64{0, 0, 2}},
+		"d@example.com": {Address: "d@example.com", FrequencyRank: 3, RecencyRank: 2, ClassDecay: []float64{0, 0, 0}},
+	}
+
+	tests := []struct {
+		testname string
+		ranking  string
+		want     map[string]int
+	}{
+		{"ordinal", "ordinal", map[string]int{"a@example.com": 3, "b@example.com": 1, "c@example.com": 3, "d@example.com": 5}},
+		{"weighted", "weighted", map[string]int{"a@example.com": 2, "b@example.com": 0, "c@example.com": 1, "d@example.com": 3}},
+		{"frequency", "frequency", map[string]int{"a@example.com": 0, "b@example.com": 1, "c@example.com": 2, "d@example.com": 3}},
+		{"recency", "recency", map[string]int{"a@example.com": 3, "b@example.com": 0, "c@example.com": 1, "d@example.com": 2}},
+		{"frecency", "frecency", map[string]int{"a@example.com": 2, "b@example.com": 0, "c@example.com": 1, "d@example.com": 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			ranker, err := newRanker(tt.ranking, 1, 2, 0)
+			assert.NoError(t, err)
+			ranker.Rank(addrmap, 2)
+			got := map[string]int{}
+			for normaddr, aD := range addrmap {
+				got[normaddr] = aD.TotalRank
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := newRanker("unknown", 1, 1, 0)
+	assert.Error(t, err)
+}
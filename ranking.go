@@ -2,9 +2,11 @@ package main
 
 import (
 	"bytes"
+	"math"
 	"sort"
 	"strings"
 	"text/template"
+	"time"
 	"unicode"
 
 	"golang.org/x/text/transform"
@@ -37,43 +39,52 @@ func getMostFrequent(names []string) string {
 			}
 		}
 	}
-	lastname = strings.TrimSpace(lastname)
-	lastname = strings.Replace(lastname, "\"", "", -1)
-	return lastname
+	return cleanName(lastname)
 }
 
 func getName(
 	normaddr string,
 	addrdata AddressData,
-	addressbook map[string]string,
+	addressbook map[string]addressbookEntry,
 	listtemplate *template.Template,
-) string {
-	bookname, ok := addressbook[normaddr]
+	ranking rankingOptions,
+) (name string, source string) {
+	entry, ok := addressbook[normaddr]
+	if !ok {
+		entry, ok = addressbook[addrdata.Address]
+	}
 	if ok {
-		return bookname
+		return entry.Name, entry.Source
 	}
 	if len(addrdata.ListId) > 0 && listtemplate != nil {
 		var tpl bytes.Buffer
 		listtemplate.Execute(&tpl, addrdata)
 		listname := tpl.String()
 		if listname != "DISABLELIST" {
-			return listname
+			return listname, ""
 		}
 
 	}
-	return getMostFrequent(addrdata.Names)
+	return selectName(addrdata, ranking), ""
 }
 
 func isMn(r rune) bool {
 	return unicode.Is(unicode.Mn, r) // Mn: nonspacing marks
 }
 
+func removeDiacritics(s string) string {
+	t := transform.Chain(norm.NFD, transform.RemoveFunc(isMn), norm.NFC)
+	normStr, _, _ := transform.String(t, s)
+	return normStr
+}
+
+// normalizeAddressNames is the name of aD without diacritics, and with the
+// letters of the scripts transliterated to ASCII.
 func normalizeAddressNames(
 	aD AddressData,
+	scripts []string,
 ) string {
-	t := transform.Chain(norm.NFD, transform.RemoveFunc(isMn), norm.NFC)
-	normStr, _, _ := transform.String(t, aD.Name)
-	return normStr
+	return removeDiacritics(transliterate(aD.Name, scripts))
 }
 
 func sortByFrequency(s []KeyValue, class int) {
@@ -96,7 +107,70 @@ func sortByRecency(s []KeyValue, class int) {
 	})
 }
 
-func getClassRanks(addrmap map[string]AddressData, class int) map[string]AddressData {
+// sortByReplies orders by the number of replies of the user, then by the
+// date of the latest one.
+func sortByReplies(s []KeyValue) {
+	sort.SliceStable(s, func(i, j int) bool {
+		a, b := s[i].addrdata, s[j].addrdata
+		if a.ReplyCount != b.ReplyCount {
+			return a.ReplyCount > b.ReplyCount
+		}
+		if a.ReplyDate != b.ReplyDate {
+			return a.ReplyDate > b.ReplyDate
+		}
+		return a.Address < b.Address
+	})
+}
+
+// rankingOptions select how addresses are ranked within their class.
+type rankingOptions struct {
+	// ranker defaults to ordinalRanker.
+	ranker   Ranker
+	halfLife time.Duration
+	now      time.Time
+	// namePolicy is one of namePolicies, frequent if empty.
+	namePolicy string
+	// nameCleanup are the nameCleanupSteps applied to names.
+	nameCleanup []string
+	// transliterate are the transliterationScripts of NormalizedName.
+	transliterate []string
+}
+
+// addDecay adds a message sent at date to the decayed sum of a class. Sums are
+// kept as log2(sum(2^(date/half-life))), which unlike the sum itself is
+// representable for any date and half-life and does not depend on when the
+// ranking is done. Without a half-life messages do not decay.
+func addDecay(sum float64, hasSum bool, date int64, halfLife time.Duration) float64 {
+	if halfLife <= 0 {
+		return mergeDecay(sum, hasSum, 0)
+	}
+	return mergeDecay(sum, hasSum, float64(date)/halfLife.Seconds())
+}
+
+// mergeDecay adds two decayed sums.
+func mergeDecay(a float64, hasA bool, b float64) float64 {
+	if !hasA {
+		return b
+	}
+	hi, lo := max(a, b), min(a, b)
+	return hi + math.Log2(1+math.Exp2(lo-hi))
+}
+
+// frecencyScore is the sum of the weights of the messages of a class, where
+// each message weighs 1 when sent now and half as much for every half-life
+// it is older.
+func frecencyScore(sum float64, hasSum bool, now time.Time, halfLife time.Duration) float64 {
+	if !hasSum || halfLife <= 0 {
+		return 0
+	}
+	return math.Exp2(sum - float64(now.Unix())/halfLife.Seconds())
+}
+
+func getClassRanks(
+	addrmap map[string]AddressData,
+	class int,
+	ranking rankingOptions,
+) map[string]AddressData {
 	s := make([]KeyValue, 0, len(addrmap))
 	for normaddr, addrdata := range addrmap {
 		s = append(s, KeyValue{normaddr, addrdata})
@@ -116,32 +190,61 @@ func getClassRanks(addrmap map[string]AddressData, class int) map[string]Address
 		addrmap[kv.normaddr] = addrdata
 	}
 
+	sortByReplies(s)
+	for rank, kv := range s {
+		addrdata, _ := addrmap[kv.normaddr]
+		addrdata.ReplyRank = rank
+		addrmap[kv.normaddr] = addrdata
+	}
+
 	for normaddr, addrdata := range addrmap {
-		addrdata.TotalRank = addrdata.FrequencyRank + addrdata.RecencyRank
+		addrdata.FrecencyScore = frecencyScore(
+			addrdata.ClassDecay[class],
+			addrdata.ClassCount[class] > 0,
+			ranking.now,
+			ranking.halfLife,
+		)
 		addrmap[normaddr] = addrdata
 	}
 
+	ranking.ranker.Rank(addrmap, class)
+
 	return addrmap
 }
 
 func calculateRanks(
 	data map[string]AddressData,
-	addressbook map[string]string,
+	addressbook map[string]addressbookEntry,
 	listtemplate *template.Template,
+	ranking rankingOptions,
 ) map[int]map[string]AddressData {
-	classedData := map[int]map[string]AddressData{
-		2: {},
-		1: {},
-		0: {},
+	if ranking.ranker == nil {
+		ranking.ranker = ordinalRanker{}
+	}
+	if ranking.now.IsZero() {
+		ranking.now = time.Now()
+	}
+	// Every address has data for all classes, at least the three of the
+	// default classes are always there.
+	classes := defaultClassRules.count()
+	for _, aD := range data {
+		classes = max(classes, len(aD.ClassCount))
+	}
+	classedData := make(map[int]map[string]AddressData, classes)
+	for class := 0; class < classes; class++ {
+		classedData[class] = map[string]AddressData{}
 	}
 	for normaddr, aD := range data {
-		aD.Name = getName(normaddr, aD, addressbook, listtemplate)
-		aD.NormalizedName = normalizeAddressNames(aD)
+		growClasses(&aD, classes)
+		aD.Address = preferredForm(aD)
+		aD = cleanupNames(aD, normaddr, ranking.nameCleanup)
+		aD.Name, aD.NameSource = getName(normaddr, aD, addressbook, listtemplate, ranking)
+		aD.NormalizedName = normalizeAddressNames(aD, ranking.transliterate)
 		classedData[aD.Class][normaddr] = aD
 	}
 
-	for class := 2; class >= 0; class-- {
-		classedData[class] = getClassRanks(classedData[class], class)
+	for class := classes - 1; class >= 0; class-- {
+		classedData[class] = getClassRanks(classedData[class], class, ranking)
 	}
 	return classedData
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrecencyScore(t *testing.T) {
+	halfLife := 24 * time.Hour
+	now := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	dates := []time.Time{
+		now,
+		now.Add(-24 * time.Hour),
+		now.Add(-48 * time.Hour),
+		now.Add(-1000 * 24 * time.Hour),
+	}
+	want := 1 + 0.5 + 0.25 + math.Pow(2, -1000)
+
+	sum := 0.0
+	for i, date := range dates {
+		sum = addDecay(sum, i > 0, date.Unix(), halfLife)
+	}
+	assert.InDelta(t, want, frecencyScore(sum, true, now, halfLife), 1e-9)
+
+	first := addDecay(0, false, dates[0].Unix(), halfLife)
+	first = addDecay(first, true, dates[1].Unix(), halfLife)
+	second := addDecay(0, false, dates[2].Unix(), halfLife)
+	second = addDecay(second, true, dates[3].Unix(), halfLife)
+	assert.InDelta(t, want, frecencyScore(mergeDecay(first, true, second), true, now, halfLife), 1e-9)
+
+	assert.Equal(t, 0.0, frecencyScore(sum, false, now, halfLife))
+}
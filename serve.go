@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// The protocol spoken over the socket is line based: the client sends the
+// query as a single line, the server answers with one tab separated address
+// and name line per result and closes the connection.
+
+// queryServer keeps the ranked addresses in memory for answering queries.
+type queryServer struct {
+	mu     sync.RWMutex
+	ranked []AddressData
+	path   string
+	limit  int
+	// transliterate are the scripts transliterated when reading the output
+	// without its sidecar.
+	transliterate []string
+}
+
+func (s *queryServer) reload() error {
+	ranked, err := loadRankedAddresses(s.path, s.transliterate)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.ranked = ranked
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *queryServer) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	term, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return
+	}
+	s.mu.RLock()
+	matches := queryAddresses(s.ranked, strings.TrimSpace(term), s.limit)
+	s.mu.RUnlock()
+	w := bufio.NewWriter(conn)
+	printQueryResults(w, matches, "aerc")
+	w.Flush()
+}
+
+// watchOutput reloads the addresses whenever the output is regenerated.
+func (s *queryServer) watchOutput() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(s.path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		defer watcher.Close()
+		timer := time.NewTimer(time.Second)
+		timer.Stop()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Name == s.path || event.Name == sidecarPath(s.path) {
+					timer.Reset(500 * time.Millisecond)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Fprintln(os.Stderr, "watch error:", err)
+			case <-timer.C:
+				if err := s.reload(); err != nil {
+					fmt.Fprintln(os.Stderr, "Couldn't reload addresses:", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// listenSocket listens on the unix socket at path, removing the socket of a
+// previous server that did not shut down cleanly.
+func listenSocket(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a server is already listening on %s", path)
+	}
+	os.Remove(path)
+	os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	return net.Listen("unix", path)
+}
+
+func runServer(config Config) error {
+	s := &queryServer{path: config.outputpath, limit: config.queryLimit, transliterate: config.transliterate}
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if err := s.watchOutput(); err != nil {
+		return err
+	}
+	listener, err := listenSocket(config.socketpath)
+	if err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+	fmt.Println("Serving", len(s.ranked), "addresses on", config.socketpath)
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// queryServerAddresses asks the server listening at path for the results of
+// term.
+func queryServerAddresses(path string, term string) ([]AddressData, error) {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := fmt.Fprintln(conn, strings.ReplaceAll(term, "\n", " ")); err != nil {
+		return nil, err
+	}
+	matches := []AddressData{}
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		slice := strings.SplitN(scanner.Text(), "\t", 2)
+		aD := AddressData{Address: slice[0]}
+		if len(slice) > 1 {
+			aD.Name = slice[1]
+		}
+		matches = append(matches, aD)
+	}
+	return matches, scanner.Err()
+}
+
+// runClient queries the server, falling back to searching the output
+// directly if no server is running.
+func runClient(config Config, args []string) error {
+	matches, err := queryServerAddresses(config.socketpath, strings.Join(args, " "))
+	if err != nil {
+		return runQuery(config, args)
+	}
+	printQueryResults(os.Stdout, matches, config.queryFormat)
+	return nil
+}
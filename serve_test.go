@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryServer(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "addressbook.tsv")
+	data := walkSources(mailSources("./testdata/endtoend"), parseOptions{}, nil)
+	ranked := saveData(calculateRanks(data, nil, nil, rankingOptions{}), path, "template", "", template.Must(template.New("output").Parse("{{.Address}}\n")), nil, false)
+	assert.NoError(t, saveSidecar(ranked, path))
+
+	s := &queryServer{path: path}
+	assert.NoError(t, s.reload())
+	socketpath := filepath.Join(dir, "query.sock")
+	listener, err := listenSocket(socketpath)
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+
+	_, err = listenSocket(socketpath)
+	assert.Error(t, err)
+
+	matches, err := queryServerAddresses(socketpath, "diacritics ouo")
+	assert.NoError(t, err)
+	assert.Equal(t, []AddressData{{Address: "diacritics@hungary.hu", Name: "öüóőúéáű"}}, matches)
+
+	matches, err = queryServerAddresses(socketpath, "nothing matches this")
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+}
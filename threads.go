@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// threadReply is a message of the user replying to the messages with the ids
+// in Parents.
+type threadReply struct {
+	ID      string
+	Parents []string
+	Date    int64
+	Weight  int
+}
+
+// threadIndex records who sent which message and which messages the user
+// replied to. Replies can only be credited to the senders of the messages
+// replied to once every source is read, as the two are usually in different
+// folders.
+type threadIndex struct {
+	// Senders maps message ids to the address of their sender.
+	Senders map[string]string
+	Replies []threadReply
+}
+
+func newThreadIndex() *threadIndex {
+	return &threadIndex{Senders: make(map[string]string)}
+}
+
+// replyParents are the ids of the messages h is a reply to: those in
+// In-Reply-To or, if it has none, the last one in References.
+func replyParents(h *mail.Header) []string {
+	if parents, err := h.MsgIDList("In-Reply-To"); err == nil && len(parents) > 0 {
+		return parents
+	}
+	if references, err := h.MsgIDList("References"); err == nil && len(references) > 0 {
+		return references[len(references)-1:]
+	}
+	return nil
+}
+
+// add records the message with header h sent at date. Messages of the user
+// are recorded as replies if they are one.
+func (t *threadIndex) add(h *mail.Header, date time.Time, opts parseOptions) {
+	from, err := h.AddressList("from")
+	if err != nil || len(from) == 0 {
+		return
+	}
+	sender := strings.ToLower(from[0].Address)
+	id, _ := h.MessageID()
+	if id != "" {
+		t.Senders[id] = canonicalAddress(sender, opts.canonicalize)
+	}
+	if !opts.sent && !isUserAddress(sender, opts.useraddresses) {
+		return
+	}
+	if parents := replyParents(h); len(parents) > 0 {
+		t.Replies = append(t.Replies, threadReply{
+			ID:      id,
+			Parents: parents,
+			Date:    date.Unix(),
+			Weight:  max(opts.weight, 1),
+		})
+	}
+}
+
+// merge adds everything recorded in other.
+func (t *threadIndex) merge(other *threadIndex) {
+	if other == nil {
+		return
+	}
+	for id, sender := range other.Senders {
+		t.Senders[id] = sender
+	}
+	t.Replies = append(t.Replies, other.Replies...)
+}
+
+// apply credits every reply of the user within the window of opts to the
+// senders of the messages replied to, as long as those are in data and are
+// not the user. A reply found in several places is only counted once.
+func (t *threadIndex) apply(data map[string]AddressData, opts parseOptions) {
+	counted := make(map[string]bool)
+	for _, reply := range t.Replies {
+		if !opts.inWindow(time.Unix(reply.Date, 0)) {
+			continue
+		}
+		if reply.ID != "" {
+			if counted[reply.ID] {
+				continue
+			}
+			counted[reply.ID] = true
+		}
+		credited := make(map[string]bool)
+		for _, parent := range reply.Parents {
+			sender, ok := t.Senders[parent]
+			if !ok || credited[sender] || isUserAddress(sender, opts.useraddresses) {
+				continue
+			}
+			credited[sender] = true
+			aD, ok := data[sender]
+			if !ok {
+				continue
+			}
+			aD.ReplyCount += reply.Weight
+			aD.ReplyDate = max(aD.ReplyDate, reply.Date)
+			data[sender] = aD
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplyParents(t *testing.T) {
+	tests := []struct {
+		testname   string
+		inReplyTo  string
+		references string
+		want       []string
+	}{
+		{"No reply", "", "", nil},
+		{"In-Reply-To", "<a@example.com>", "<x@example.com> <y@example.com>", []string{"a@example.com"}},
+		{"Several In-Reply-To", "<a@example.com> <b@example.com>", "", []string{"a@example.com", "b@example.com"}},
+		{"Last of References", "", "<x@example.com> <y@example.com>", []string{"y@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			h := &mail.Header{}
+			if tt.inReplyTo != "" {
+				h.Set("In-Reply-To", tt.inReplyTo)
+			}
+			if tt.references != "" {
+				h.Set("References", tt.references)
+			}
+			assert.Equal(t, tt.want, replyParents(h))
+		})
+	}
+}
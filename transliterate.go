@@ -0,0 +1,236 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// transliterationScripts are the scripts names can be transliterated from for
+// NormalizedName, see transliterate.
+var transliterationScripts = []string{"latin", "greek", "cyrillic", "kana", "hangul"}
+
+// latinFolding spells the Latin letters which are not a base letter with
+// combining marks in ASCII.
+var latinFolding = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d",
+	'þ': "th", 'ı': "i", 'ħ': "h", 'ŋ': "ng", 'ŀ': "l", 'ŧ': "t", 'ĸ': "q",
+	'ſ': "s", 'ƒ': "f", 'ɨ': "i", 'ʉ': "u",
+}
+
+// greekTransliteration follows ELOT 743 without its context dependent rules.
+var greekTransliteration = map[string]string{
+	"α": "a", "β": "v", "γ": "g", "δ": "d", "ε": "e", "ζ": "z", "η": "i",
+	"θ": "th", "ι": "i", "κ": "k", "λ": "l", "μ": "m", "ν": "n", "ξ": "x",
+	"ο": "o", "π": "p", "ρ": "r", "σ": "s", "ς": "s", "τ": "t", "υ": "y",
+	"φ": "f", "χ": "ch", "ψ": "ps", "ω": "o",
+	"ου": "ou", "αυ": "av", "ευ": "ev", "γγ": "ng", "γκ": "gk",
+}
+
+// cyrillicTransliteration follows ICAO Doc 9303 as used in passports, with
+// the letters of Ukrainian, Belarusian, Serbian and Macedonian.
+var cyrillicTransliteration = map[string]string{
+	"а": "a", "б": "b", "в": "v", "г": "g", "д": "d", "е": "e", "ё": "e",
+	"ж": "zh", "з": "z", "и": "i", "й": "i", "к": "k", "л": "l", "м": "m",
+	"н": "n", "о": "o", "п": "p", "р": "r", "с": "s", "т": "t", "у": "u",
+	"ф": "f", "х": "kh", "ц": "ts", "ч": "ch", "ш": "sh", "щ": "shch",
+	"ъ": "ie", "ы": "y", "ь": "", "э": "e", "ю": "iu", "я": "ia",
+	"є": "ie", "і": "i", "ї": "i", "ґ": "g", "ў": "u", "ђ": "d", "ј": "j",
+	"љ": "lj", "њ": "nj", "ћ": "c", "џ": "dz", "ѓ": "g", "ќ": "k", "ѕ": "dz",
+}
+
+// kanaTransliteration is the Hepburn romanization of hiragana, katakana is
+// looked up by its hiragana.
+var kanaTransliteration = map[string]string{
+	"あ": "a", "い": "i", "う": "u", "え": "e", "お": "o",
+	"か": "ka", "き": "ki", "く": "ku", "け": "ke", "こ": "ko",
+	"が": "ga", "ぎ": "gi", "ぐ": "gu", "げ": "ge", "ご": "go",
+	"さ": "sa", "し": "shi", "す": "su", "せ": "se", "そ": "so",
+	"ざ": "za", "じ": "ji", "ず": "zu", "ぜ": "ze", "ぞ": "zo",
+	"た": "ta", "ち": "chi", "つ": "tsu", "て": "te", "と": "to",
+	"だ": "da", "ぢ": "ji", "づ": "zu", "で": "de", "ど": "do",
+	"な": "na", "に": "ni", "ぬ": "nu", "ね": "ne", "の": "no",
+	"は": "ha", "ひ": "hi", "ふ": "fu", "へ": "he", "ほ": "ho",
+	"ば": "ba", "び": "bi", "ぶ": "bu", "べ": "be", "ぼ": "bo",
+	"ぱ": "pa", "ぴ": "pi", "ぷ": "pu", "ぺ": "pe", "ぽ": "po",
+	"ま": "ma", "み": "mi", "む": "mu", "め": "me", "も": "mo",
+	"や": "ya", "ゆ": "yu", "よ": "yo",
+	"ら": "ra", "り": "ri", "る": "ru", "れ": "re", "ろ": "ro",
+	"わ": "wa", "ゐ": "i", "ゑ": "e", "を": "o", "ん": "n", "ゔ": "vu",
+	"ぁ": "a", "ぃ": "i", "ぅ": "u", "ぇ": "e", "ぉ": "o", "ゎ": "wa",
+}
+
+// The Revised Romanization of the initials, vowels and finals of Hangul
+// syllables, in the order of their jamo.
+var (
+	hangulInitials = []string{
+		"g", "kk", "n", "d", "tt", "r", "m", "b", "pp", "s", "ss", "", "j", "jj",
+		"ch", "k", "t", "p", "h",
+	}
+	hangulVowels = []string{
+		"a", "ae", "ya", "yae", "eo", "e", "yeo", "ye", "o", "wa", "wae", "oe",
+		"yo", "u", "wo", "we", "wi", "yu", "eu", "ui", "i",
+	}
+	hangulFinals = []string{
+		"", "k", "k", "k", "n", "n", "n", "t", "l", "k", "m", "l", "l", "l",
+		"p", "l", "m", "p", "p", "t", "t", "ng", "t", "t", "k", "t", "p", "t",
+	}
+)
+
+// transliterate spells the letters of s in the scripts in ASCII:
+//
+//   - latin: letters like ß, ø or ł, marks are removed by removeDiacritics
+//   - greek: ELOT 743
+//   - cyrillic: ICAO Doc 9303
+//   - kana: Hepburn romanization of hiragana and katakana
+//   - hangul: Revised Romanization of Korean
+//
+// Upper case letters become capitalized, or upper case if a neighbouring
+// letter is upper case too. Kana and Hangul, which have no case, are
+// capitalized at the start of every word. Han characters are left as they are,
+// as reading them needs a dictionary.
+func transliterate(s string, scripts []string) string {
+	if len(scripts) == 0 {
+		return s
+	}
+	enabled := make(map[string]bool, len(scripts))
+	for _, script := range scripts {
+		enabled[script] = true
+	}
+	runes := []rune(norm.NFC.String(s))
+	var b strings.Builder
+	for i := 0; i < len(runes); {
+		out, n, caseless := transliterateAt(runes, i, enabled)
+		if n == 0 {
+			b.WriteRune(runes[i])
+			i++
+			continue
+		}
+		switch {
+		case caseless && (i == 0 || !isCaselessLetter(runes[i-1])):
+			out = capitalize(out, false)
+		case unicode.IsUpper(runes[i]):
+			allCaps := (i+n < len(runes) && unicode.IsUpper(runes[i+n])) ||
+				(i > 0 && unicode.IsUpper(runes[i-1]))
+			out = capitalize(out, allCaps)
+		}
+		b.WriteString(out)
+		i += n
+	}
+	return b.String()
+}
+
+// transliterateAt transliterates the letter, or the two letters if they are
+// spelled together, at runes[i]. It returns the spelling, the number of runes
+// spelled and whether they are from a script without case. n is 0 if the
+// letter is not transliterated.
+func transliterateAt(runes []rune, i int, enabled map[string]bool) (out string, n int, caseless bool) {
+	r := runes[i]
+	switch {
+	case enabled["latin"] && unicode.Is(unicode.Latin, r):
+		out, ok := latinFolding[unicode.ToLower(r)]
+		if !ok {
+			return "", 0, false
+		}
+		return out, 1, false
+	case enabled["greek"] && unicode.Is(unicode.Greek, r):
+		out, n := lookupPair(runes, i, greekTransliteration)
+		return out, n, false
+	case enabled["cyrillic"] && unicode.Is(unicode.Cyrillic, r):
+		out, n := lookupPair(runes, i, cyrillicTransliteration)
+		return out, n, false
+	case enabled["kana"] && (isKana(r) || r == '・'):
+		out, n := transliterateKana(runes, i)
+		return out, n, true
+	case enabled["hangul"] && r >= 0xAC00 && r <= 0xD7A3:
+		syllable := int(r - 0xAC00)
+		return hangulInitials[syllable/588] + hangulVowels[syllable%588/28] + hangulFinals[syllable%28], 1, true
+	}
+	return "", 0, false
+}
+
+// lookupPair looks up the lower cased letters at runes[i] in table, a pair of
+// letters first. Letters with marks not in table are looked up without them.
+func lookupPair(runes []rune, i int, table map[string]string) (string, int) {
+	letter := func(r rune) string {
+		lower := string(unicode.ToLower(r))
+		if _, ok := table[lower]; ok {
+			return lower
+		}
+		return removeDiacritics(lower)
+	}
+	if i+1 < len(runes) {
+		if out, ok := table[letter(runes[i])+letter(runes[i+1])]; ok {
+			return out, 2
+		}
+	}
+	if out, ok := table[letter(runes[i])]; ok {
+		return out, 1
+	}
+	return "", 0
+}
+
+// transliterateKana spells the kana at runes[i] together with a following
+// small ya, yu or yo. A small tsu doubles the next consonant, the long vowel
+// mark is left out and the middle dot between names becomes a space.
+func transliterateKana(runes []rune, i int) (string, int) {
+	hiragana := func(r rune) string {
+		if r >= 'ァ' && r <= 'ヶ' {
+			r -= 0x60
+		}
+		return string(r)
+	}
+	switch kana := hiragana(runes[i]); kana {
+	case "ー":
+		return "", 1
+	case "・":
+		return " ", 1
+	case "っ":
+		if i+1 < len(runes) {
+			if next, _ := transliterateKana(runes, i+1); next != "" && !strings.ContainsRune("aeiou", rune(next[0])) {
+				if strings.HasPrefix(next, "ch") {
+					return "t", 1
+				}
+				return next[:1], 1
+			}
+		}
+		return "", 1
+	default:
+		out, ok := kanaTransliteration[kana]
+		if !ok {
+			return "", 0
+		}
+		if i+1 < len(runes) && strings.HasSuffix(out, "i") && len(out) > 1 {
+			vowel, ok := map[string]string{"ゃ": "a", "ゅ": "u", "ょ": "o"}[hiragana(runes[i+1])]
+			if ok {
+				stem := strings.TrimSuffix(out, "i")
+				if stem != "sh" && stem != "ch" && stem != "j" {
+					stem += "y"
+				}
+				return stem + vowel, 2
+			}
+		}
+		return out, 1
+	}
+}
+
+// isKana checks whether r is a hiragana or katakana, including the long vowel
+// mark.
+func isKana(r rune) bool {
+	return unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || r == 'ー'
+}
+
+func isCaselessLetter(r rune) bool {
+	return isKana(r) || (r >= 0xAC00 && r <= 0xD7A3)
+}
+
+func capitalize(s string, allCaps bool) string {
+	if allCaps {
+		return strings.ToUpper(s)
+	}
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
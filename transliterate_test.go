@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransliterate(t *testing.T) {
+	tests := []struct {
+		name    string
+		scripts []string
+		want    string
+	}{
+		{"Straße", []string{"latin"}, "Strasse"},
+		{"Søren Łukasz", []string{"latin"}, "Soren Lukasz"},
+		{"Ægir ÆSIR", []string{"latin"}, "Aegir AESIR"},
+		{"Иван Петров", []string{"cyrillic"}, "Ivan Petrov"},
+		{"Щукин ЖУК", []string{"cyrillic"}, "Shchukin ZHUK"},
+		{"Олександр Їжак", []string{"cyrillic"}, "Oleksandr Izhak"},
+		{"Γιώργος Παπαδόπουλος", []string{"greek"}, "Giorgos Papadopoulos"},
+		{"Ευάγγελος", []string{"greek"}, "Evangelos"},
+		{"やまだ たろう", []string{"kana"}, "Yamada Tarou"},
+		{"キョウコ", []string{"kana"}, "Kyouko"},
+		{"マッチ コーヒー", []string{"kana"}, "Matchi Kohi"},
+		{"ジョン・スミス", []string{"kana"}, "Jon Sumisu"},
+		{"김 민준", []string{"hangul"}, "Gim Minjun"},
+		{"山田 太郎", transliterationScripts, "山田 太郎"},
+		{"Иван Straße", []string{"latin"}, "Иван Strasse"},
+		{"Иван Straße", nil, "Иван Straße"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, transliterate(tt.name, tt.scripts))
+		})
+	}
+}
+
+func TestNormalizeAddressNames(t *testing.T) {
+	aD := AddressData{Name: "Zoë Ørsted-Ψαρά"}
+	assert.Equal(t, "Zoe Ørsted-Ψαρα", normalizeAddressNames(aD, nil))
+	assert.Equal(t, "Zoe Orsted-Ψαρα", normalizeAddressNames(aD, []string{"latin"}))
+	assert.Equal(t, "Zoe Orsted-Psara", normalizeAddressNames(aD, transliterationScripts))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const vcardProdID = "-//maildir-rank-addr//EN"
+
+// urlNamespace is the RFC 4122 namespace for name based UUIDs of URLs.
+var urlNamespace = [16]byte{
+	0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1,
+	0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8,
+}
+
+// vcardUUID derives a version 5 UUID from the address, so that the same
+// address always gets the same UID and repeated exports update contacts
+// instead of duplicating them.
+func vcardUUID(address string) string {
+	h := sha1.New()
+	h.Write(urlNamespace[:])
+	h.Write([]byte("mailto:" + address))
+	u := h.Sum(nil)[:16]
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// vcardEscape escapes a text value as required by RFC 6350.
+func vcardEscape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		",", `\,`,
+		";", `\;`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// writeVCardLine writes a content line, folded after 75 octets without
+// splitting UTF-8 sequences.
+func writeVCardLine(w io.Writer, line string) {
+	for len(line) > 75 {
+		cut := 75
+		for cut > 1 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		fmt.Fprintf(w, "%s\r\n", line[:cut])
+		line = " " + line[cut:]
+	}
+	fmt.Fprintf(w, "%s\r\n", line)
+}
+
+// writeVCard writes aD as a vCard 4.0. Names other than the chosen one end
+// up as nicknames, the ranking data in X- properties. position is the place
+// of the address in the output.
+func writeVCard(w io.Writer, aD AddressData, position int) {
+	name := aD.Name
+	if name == "" {
+		name = aD.Address
+	}
+	nicknames := []string{}
+	for _, n := range aD.Names {
+		n = strings.TrimSpace(strings.Replace(n, "\"", "", -1))
+		if n != "" && n != aD.Name {
+			nicknames = append(nicknames, vcardEscape(n))
+		}
+	}
+	slices.Sort(nicknames)
+	nicknames = slices.Compact(nicknames)
+
+	writeVCardLine(w, "BEGIN:VCARD")
+	writeVCardLine(w, "VERSION:4.0")
+	writeVCardLine(w, "PRODID:"+vcardProdID)
+	writeVCardLine(w, "UID:urn:uuid:"+vcardUUID(aD.Address))
+	writeVCardLine(w, "FN:"+vcardEscape(name))
+	if len(nicknames) > 0 {
+		writeVCardLine(w, "NICKNAME:"+strings.Join(nicknames, ","))
+	}
+	writeVCardLine(w, "EMAIL:"+vcardEscape(aD.Address))
+	if aD.ListId != "" {
+		writeVCardLine(w, "X-MAILDIR-RANK-ADDR-LIST-ID:"+vcardEscape(aD.ListId))
+	}
+	writeVCardLine(w, "X-MAILDIR-RANK-ADDR-POSITION:"+strconv.Itoa(position))
+	writeVCardLine(w, "X-MAILDIR-RANK-ADDR-CLASS:"+strconv.Itoa(aD.Class))
+	writeVCardLine(w, "X-MAILDIR-RANK-ADDR-TOTAL-RANK:"+strconv.Itoa(aD.TotalRank))
+	writeVCardLine(w, "X-MAILDIR-RANK-ADDR-FREQUENCY-RANK:"+strconv.Itoa(aD.FrequencyRank))
+	writeVCardLine(w, "X-MAILDIR-RANK-ADDR-RECENCY-RANK:"+strconv.Itoa(aD.RecencyRank))
+	writeVCardLine(w, "X-MAILDIR-RANK-ADDR-FRECENCY-SCORE:"+strconv.FormatFloat(aD.FrecencyScore, 'g', 3, 64))
+	writeVCardLine(w, "END:VCARD")
+}
+
+// writeVCards writes every address as a vCard into a single stream.
+func writeVCards(w io.Writer, ranked []AddressData) error {
+	bw := bufio.NewWriter(w)
+	for i, aD := range ranked {
+		writeVCard(bw, aD, i)
+	}
+	return bw.Flush()
+}
+
+// saveVdir writes every address as a vCard into its own file in dir, named
+// after its UID, as expected by vdirsyncer and khard. Files are only
+// rewritten if their content changed, and cards written by an earlier run
+// for addresses that are gone are removed.
+func saveVdir(dir string, ranked []AddressData) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	written := make(map[string]bool)
+	for i, aD := range ranked {
+		name := vcardUUID(aD.Address) + ".vcf"
+		if written[name] {
+			continue
+		}
+		written[name] = true
+		var card bytes.Buffer
+		writeVCard(&card, aD, i)
+		path := filepath.Join(dir, name)
+		if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, card.Bytes()) {
+			continue
+		}
+		if err := os.WriteFile(path, card.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".vcf" || written[entry.Name()] {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err == nil && bytes.Contains(content, []byte("PRODID:"+vcardProdID)) {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
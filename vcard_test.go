@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVCardUUID(t *testing.T) {
+	assert.Equal(t, "b0ea5304-1d7c-56a3-9edd-e9e567ea75cb", vcardUUID("foo@bar.com"))
+}
+
+func TestWriteVCard(t *testing.T) {
+	aD := AddressData{
+		Address:   "jane@corp.com",
+		Name:      "Jane Doe",
+		Names:     []string{"Jane Doe", "Doe, Jane", "\"Jane Doe\"", "Jane; the \\ boss"},
+		Class:     2,
+		TotalRank: 3,
+	}
+	var buf bytes.Buffer
+	writeVCard(&buf, aD, 7)
+	card := buf.String()
+	assert.True(t, strings.HasPrefix(card, "BEGIN:VCARD\r\nVERSION:4.0\r\n"))
+	assert.Contains(t, card, "\r\nFN:Jane Doe\r\n")
+	assert.Contains(t, card, "\r\nNICKNAME:Doe\\, Jane,Jane\\; the \\\\ boss\r\n")
+	assert.Contains(t, card, "\r\nEMAIL:jane@corp.com\r\n")
+	assert.Contains(t, card, "\r\nX-MAILDIR-RANK-ADDR-POSITION:7\r\n")
+	assert.Contains(t, card, "\r\nX-MAILDIR-RANK-ADDR-TOTAL-RANK:3\r\n")
+	assert.True(t, strings.HasSuffix(card, "\r\nEND:VCARD\r\n"))
+
+	buf.Reset()
+	writeVCard(&buf, AddressData{Address: "nobody@example.com"}, 0)
+	assert.Contains(t, buf.String(), "\r\nFN:nobody@example.com\r\n")
+}
+
+func TestWriteVCardLineFolding(t *testing.T) {
+	var buf bytes.Buffer
+	line := "FN:" + strings.Repeat("é", 60)
+	writeVCardLine(&buf, line)
+	folded := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	assert.Greater(t, len(folded), 1)
+	unfolded := folded[0]
+	for _, l := range folded {
+		assert.LessOrEqual(t, len(l), 75)
+	}
+	for _, l := range folded[1:] {
+		assert.True(t, strings.HasPrefix(l, " "))
+		unfolded += l[1:]
+	}
+	assert.Equal(t, line, unfolded)
+}
+
+func TestSaveVdir(t *testing.T) {
+	dir := t.TempDir()
+	foreign := filepath.Join(dir, "foreign.vcf")
+	os.WriteFile(foreign, []byte("BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Foreign\r\nEND:VCARD\r\n"), 0o644)
+	ranked := []AddressData{
+		{Address: "foo@bar.com", Name: "Foo"},
+		{Address: "gone@example.com", Name: "Gone"},
+	}
+	assert.NoError(t, saveVdir(dir, ranked))
+	foo := filepath.Join(dir, vcardUUID("foo@bar.com")+".vcf")
+	gone := filepath.Join(dir, vcardUUID("gone@example.com")+".vcf")
+	assert.FileExists(t, foo)
+	assert.FileExists(t, gone)
+
+	ranked = []AddressData{{Address: "foo@bar.com", Name: "Foo Bar"}}
+	assert.NoError(t, saveVdir(dir, ranked))
+	content, _ := os.ReadFile(foo)
+	assert.Contains(t, string(content), "FN:Foo Bar\r\n")
+	assert.NoFileExists(t, gone)
+	assert.FileExists(t, foreign)
+}
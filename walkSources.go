@@ -1,31 +1,227 @@
 package main
 
 import (
+	"fmt"
+	"maps"
+	"reflect"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// mailSource is a folder (or notmuch query) mail is read from. In the config
+// it is either given by its path alone or as a table with further options.
+type mailSource struct {
+	Path  string `mapstructure:"path"`
+	Label string `mapstructure:"label"`
+	// Include and Exclude are patterns matched against the path of every
+	// file relative to Path, see pathPattern.
+	Include []string `mapstructure:"include"`
+	Exclude []string `mapstructure:"exclude"`
+	// Sent treats every message as sent by the user.
+	Sent bool `mapstructure:"sent"`
+	// Weight is how many times every message is counted.
+	Weight int `mapstructure:"weight"`
+	// Since is the date before which messages are ignored, see
+	// parseTimeBound.
+	Since string `mapstructure:"since"`
+
+	since time.Time
+	// include holds the global and the own include patterns of the source,
+	// a file has to match both. exclude holds the global exclude patterns
+	// followed by the own ones, so that the latter can override the former.
+	include []pathPatterns
+	exclude pathPatterns
+}
+
+// relativeTimePattern matches times relative to now, such as 2y or 6m.
+var relativeTimePattern = regexp.MustCompile(`^(\d+)([ymwd])$`)
+
+// parseTimeBound parses the since and until options: a date (2019-06-30,
+// 2019-06 or 2019, the start of the month or year), a date and time in RFC
+// 3339 format, or a number of years, months, weeks or days before now
+// (2y, 6m, 3w, 10d). An empty value gives the zero time.
+func parseTimeBound(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if m := relativeTimePattern.FindStringSubmatch(value); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		switch m[2] {
+		case "y":
+			return now.AddDate(-n, 0, 0), nil
+		case "m":
+			return now.AddDate(0, -n, 0), nil
+		case "w":
+			return now.AddDate(0, 0, -7*n), nil
+		default:
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	for _, layout := range []string{time.DateOnly, "2006-01", "2006"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a date like 2019-06-30 nor a time before now like 2y", value)
+}
+
+// mailSourceDecodeHook lets the maildir setting mix plain paths and tables,
+// and keeps the comma separated form working.
+func mailSourceDecodeHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String {
+		return data, nil
+	}
+	switch to {
+	case reflect.TypeOf(mailSource{}):
+		return mailSource{Path: data.(string)}, nil
+	case reflect.TypeOf([]mailSource{}):
+		sources := []mailSource{}
+		for _, path := range strings.Split(data.(string), ",") {
+			if path != "" {
+				sources = append(sources, mailSource{Path: path})
+			}
+		}
+		return sources, nil
+	}
+	return data, nil
+}
+
+// validate fills in the defaults of a source and checks its options. The
+// global include and exclude patterns are combined with the own ones.
+func (source *mailSource) validate(include []string, exclude []string) error {
+	if source.Path == "" {
+		return fmt.Errorf("maildir entries need a path")
+	}
+	if source.Label == "" {
+		source.Label = source.Path
+	}
+	source.include = nil
+	for _, patterns := range [][]string{include, source.Include} {
+		if len(patterns) == 0 {
+			continue
+		}
+		compiled, err := compilePathPatterns(patterns)
+		if err != nil {
+			return fmt.Errorf("%s: %w", source.Label, err)
+		}
+		source.include = append(source.include, compiled)
+	}
+	var err error
+	source.exclude, err = compilePathPatterns(slices.Concat(exclude, source.Exclude))
+	if err != nil {
+		return fmt.Errorf("%s: %w", source.Label, err)
+	}
+	if source.Weight < 0 {
+		return fmt.Errorf("%s: weight can not be negative", source.Label)
+	}
+	source.since, err = parseTimeBound(source.Since, time.Now())
+	if err != nil {
+		return fmt.Errorf("%s: %w", source.Label, err)
+	}
+	return nil
+}
+
+// parseOptions adds the options of the source to opts.
+func (source mailSource) parseOptions(opts parseOptions) parseOptions {
+	opts.label = source.Label
+	opts.sent = source.Sent
+	opts.weight = max(source.Weight, 1)
+	if source.since.After(opts.since) {
+		opts.since = source.since
+	}
+	return opts
+}
+
+// excluded checks whether the folder at rel, relative to the root of the
+// source, is left out.
+func (source mailSource) excluded(rel string) bool {
+	return source.exclude.matches(rel, true)
+}
+
+// included checks whether the file at rel, relative to the root of the
+// source, is to be read.
+func (source mailSource) included(rel string) bool {
+	if source.exclude.matches(rel, false) {
+		return false
+	}
+	for _, include := range source.include {
+		if !include.matches(rel, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeSources merges dataNew into data. dataNew is left untouched, so it is
+// safe to merge cached data.
 func mergeSources(data map[string]AddressData, dataNew map[string]AddressData) map[string]AddressData {
-	if len(data) == 0 {
-		return dataNew
+	if data == nil {
+		data = make(map[string]AddressData, len(dataNew))
 	}
 	// Merge dataNew into data
 	for str, addr := range dataNew {
 		orig, ok := data[str]
 		if !ok {
+			addr.Names = slices.Clone(addr.Names)
+			addr.Sources = slices.Clone(addr.Sources)
+			addr.ClassCount = slices.Clone(addr.ClassCount)
+			addr.ClassDate = slices.Clone(addr.ClassDate)
+			addr.ClassDecay = slices.Clone(addr.ClassDecay)
+			addr.Forms = maps.Clone(addr.Forms)
+			addr.NameUses = maps.Clone(addr.NameUses)
 			data[str] = addr
 		} else {
 			orig.Names = append(orig.Names, addr.Names...)
 			if addr.Class > orig.Class {
 				orig.Class = addr.Class
 			}
-			for i := range orig.ClassCount {
+			growClasses(&orig, len(addr.ClassCount))
+			for i := range addr.ClassDecay {
+				if addr.ClassCount[i] > 0 {
+					orig.ClassDecay[i] = mergeDecay(
+						orig.ClassDecay[i],
+						orig.ClassCount[i] > 0,
+						addr.ClassDecay[i],
+					)
+				}
+			}
+			for i := range addr.ClassCount {
 				orig.ClassCount[i] += addr.ClassCount[i]
 			}
-			for i := range orig.ClassDate {
+			for i := range addr.ClassDate {
 				if addr.ClassDate[i] > orig.ClassDate[i] {
 					orig.ClassDate[i] = addr.ClassDate[i]
 				}
 			}
+			orig.IsAutomated = orig.IsAutomated || addr.IsAutomated
+			orig.NameUses = mergeNameUses(orig.NameUses, addr.NameUses)
+			if len(addr.Forms) > 0 && orig.Forms == nil {
+				orig.Forms = make(map[string]addressForm)
+			}
+			for form, count := range addr.Forms {
+				sum := orig.Forms[form]
+				sum.Seen += count.Seen
+				sum.Written += count.Written
+				orig.Forms[form] = sum
+			}
+			orig.ReplyCount += addr.ReplyCount
+			orig.ReplyDate = max(orig.ReplyDate, addr.ReplyDate)
+			for _, label := range addr.Sources {
+				if !slices.Contains(orig.Sources, label) {
+					orig.Sources = append(orig.Sources, label)
+				}
+			}
+			if orig.ListId == "" {
+				orig.ListName = addr.ListName
+				orig.ListId = addr.ListId
+			}
 			data[str] = orig
 		}
 	}
@@ -33,14 +229,21 @@ func mergeSources(data map[string]AddressData, dataNew map[string]AddressData) m
 }
 
 func walkSources(
-	maildirs []string,
-	useraddresses []*regexp.Regexp,
-	customFilters []*regexp.Regexp,
+	sources []mailSource,
+	opts parseOptions,
+	cache *addressCache,
 ) map[string]AddressData {
 	data := make(map[string]AddressData)
-	for _, maildir := range maildirs {
-		dataNew := walkMaildir(maildir, useraddresses, customFilters)
+	threads := newThreadIndex()
+	for _, source := range sources {
+		var dataNew map[string]AddressData
+		if query, ok := strings.CutPrefix(source.Path, notmuchPrefix); ok {
+			dataNew = walkNotmuch(query, source.parseOptions(opts), threads)
+		} else {
+			dataNew = walkMaildir(source, source.parseOptions(opts), cache, threads)
+		}
 		data = mergeSources(data, dataNew)
 	}
+	threads.apply(data, opts)
 	return data
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMailSourceIncluded(t *testing.T) {
+	source := mailSource{
+		Path:    "mail",
+		Include: []string{"work", "personal/INBOX"},
+		Exclude: []string{"*/Trash", "*.bak"},
+	}
+	assert.NoError(t, source.validate(nil, nil))
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"work/cur/1", true},
+		{"work/Trash/cur/1", false},
+		{"personal/INBOX/new/2", true},
+		{"personal/Sent/cur/3", false},
+		{"work/cur/1.bak", false},
+		{"other/cur/4", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rel, func(t *testing.T) {
+			assert.Equal(t, tt.want, source.included(tt.rel))
+		})
+	}
+	assert.True(t, mailSource{Path: "mail"}.included("anything/at/all"))
+}
+
+func TestMailSourceConfig(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("toml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+maildir = [
+    "~/.mail",
+    { path = "~/work", label = "work", exclude = ["Spam"], sent = false, weight = 3, since = "2020-01-01" },
+]
+`)))
+	var sources []mailSource
+	assert.NoError(t, v.UnmarshalKey("maildir", &sources, viper.DecodeHook(mailSourceDecodeHook)))
+	assert.Equal(t, []mailSource{
+		{Path: "~/.mail"},
+		{Path: "~/work", Label: "work", Exclude: []string{"Spam"}, Weight: 3, Since: "2020-01-01"},
+	}, sources)
+
+	v = viper.New()
+	v.Set("maildir", "~/.mail,~/archive")
+	sources = nil
+	assert.NoError(t, v.UnmarshalKey("maildir", &sources, viper.DecodeHook(mailSourceDecodeHook)))
+	assert.Equal(t, []mailSource{{Path: "~/.mail"}, {Path: "~/archive"}}, sources)
+
+	assert.Error(t, (&mailSource{Path: "x", Since: "last year"}).validate(nil, nil))
+	assert.Error(t, (&mailSource{Path: "x", Weight: -1}).validate(nil, nil))
+	assert.Error(t, (&mailSource{Path: "x", Exclude: []string{"["}}).validate(nil, nil))
+	source := mailSource{Path: "x"}
+	assert.NoError(t, source.validate(nil, nil))
+	assert.Equal(t, "x", source.Label)
+}
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"", time.Time{}},
+		{"2y", time.Date(2023, 3, 31, 12, 0, 0, 0, time.UTC)},
+		{"1m", time.Date(2025, 3, 3, 12, 0, 0, 0, time.UTC)},
+		{"2w", time.Date(2025, 3, 17, 12, 0, 0, 0, time.UTC)},
+		{"10d", time.Date(2025, 3, 21, 12, 0, 0, 0, time.UTC)},
+		{"2019-06-30", time.Date(2019, 6, 30, 0, 0, 0, 0, time.Local)},
+		{"2019-06", time.Date(2019, 6, 1, 0, 0, 0, 0, time.Local)},
+		{"2019", time.Date(2019, 1, 1, 0, 0, 0, 0, time.Local)},
+		{"2019-06-30T10:00:00Z", time.Date(2019, 6, 30, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseTimeBound(tt.value, now)
+			assert.NoError(t, err)
+			assert.True(t, tt.want.Equal(got), "got %v", got)
+		})
+	}
+	for _, bad := range []string{"2 years", "1h", "yesterday", "2019-13-01"} {
+		_, err := parseTimeBound(bad, now)
+		assert.Error(t, err, bad)
+	}
+}
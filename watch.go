@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// mbsyncUIDPattern matches the UID mbsync puts into maildir file names, which
+// changes when a message is moved between folders.
+var mbsyncUIDPattern = regexp.MustCompile(`,U=\d+`)
+
+// maildirKey strips the info part (flags) from a maildir file name, so that
+// a message keeps its identity when it is moved from new to cur or flagged.
+func maildirKey(path string) string {
+	base := filepath.Base(path)
+	if i := strings.Index(base, ":"); i >= 0 {
+		base = base[:i]
+	}
+	return mbsyncUIDPattern.ReplaceAllString(base, "")
+}
+
+// watchIgnored reports whether a file or directory should not be watched:
+// hidden files, the .notmuch folder and everything still being delivered into
+// a tmp folder. Hidden folders are watched, as maildir++ keeps its folders in
+// them, see walkMessageFiles.
+func watchIgnored(path string, isDir bool) bool {
+	base := filepath.Base(path)
+	if base == ".notmuch" || (strings.HasPrefix(base, ".") && !isDir) {
+		return true
+	}
+	return filepath.Base(filepath.Dir(path)) == "tmp"
+}
+
+// watchKey identifies a message within a source. Sources may overlap, a
+// message found in several of them is counted once for each.
+type watchKey struct {
+	source int
+	name   string
+}
+
+// mailWatcher keeps track of the files that were already counted and the
+// files that arrived since the addressbook was last written, along with the
+// sources every watched directory belongs to.
+type mailWatcher struct {
+	watcher *fsnotify.Watcher
+	sources []mailSource
+	dirs    map[string][]int
+	known   map[watchKey]bool
+	pending map[watchKey]string
+}
+
+// addRecursive watches root, which is the path of the source or a folder
+// below it, and every directory below it. Files found along the way are
+// queued if queue is set, otherwise they are only recorded as already
+// counted.
+func (w *mailWatcher) addRecursive(source int, root string, queue bool) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(w.sources[source].Path, path)
+		if (path != root && watchIgnored(path, d.IsDir())) || (rel != "." && w.sources[source].excluded(rel)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if err := w.watcher.Add(path); err != nil {
+				return fmt.Errorf("watching %s: %w", path, err)
+			}
+			if !slices.Contains(w.dirs[path], source) {
+				w.dirs[path] = append(w.dirs[path], source)
+			}
+			return nil
+		}
+		if !w.sources[source].included(rel) {
+			return nil
+		}
+		if queue {
+			w.queue(source, path)
+		} else {
+			w.known[watchKey{source, maildirKey(path)}] = true
+		}
+		return nil
+	})
+}
+
+// queue schedules path for parsing with the options of source unless the
+// message was seen in that source before under a different name.
+func (w *mailWatcher) queue(source int, path string) {
+	key := watchKey{source, maildirKey(path)}
+	if w.known[key] {
+		return
+	}
+	w.known[key] = true
+	w.pending[key] = path
+}
+
+// flush parses the queued files with the options of their sources and merges
+// their addresses into data.
+func (w *mailWatcher) flush(
+	data map[string]AddressData,
+	config Config,
+) map[string]AddressData {
+	bySource := make(map[int][]string)
+	for key, path := range w.pending {
+		bySource[key.source] = append(bySource[key.source], path)
+	}
+	w.pending = make(map[watchKey]string)
+	for source, paths := range bySource {
+		messageFiles := make(chan messageFile, len(paths))
+		for _, path := range paths {
+			messageFiles <- messageFile{path: path}
+		}
+		close(messageFiles)
+		opts := w.sources[source].parseOptions(config.parseOptions())
+		contributions := <-parseMessages(messageFiles, false, opts)
+		if contribution, ok := contributions[""]; ok {
+			data = mergeSources(data, contribution.Addresses)
+		}
+	}
+	return data
+}
+
+// watchSources keeps running after the initial scan, parsing mail as it
+// arrives in any of the maildirs and rewriting the addressbook once no new
+// mail has arrived for the debounce period. Messages that are deleted
+// meanwhile are only forgotten and replies only counted on the next full run.
+func watchSources(
+	data map[string]AddressData,
+	addressbook map[string]addressbookEntry,
+	config Config,
+) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	w := &mailWatcher{
+		watcher: watcher,
+		sources: config.sources,
+		dirs:    make(map[string][]int),
+		known:   make(map[watchKey]bool),
+		pending: make(map[watchKey]string),
+	}
+	watched := 0
+	for i, source := range config.sources {
+		if strings.HasPrefix(source.Path, notmuchPrefix) {
+			continue
+		}
+		if err := w.addRecursive(i, source.Path, false); err != nil {
+			return err
+		}
+		watched++
+	}
+	fmt.Println("Watching", watched, "folders for new mail.")
+
+	timer := time.NewTimer(config.watchDebounce)
+	timer.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !event.Has(fsnotify.Create) {
+				continue
+			}
+			sources, ok := w.dirs[filepath.Dir(event.Name)]
+			if !ok {
+				continue
+			}
+			info, err := os.Stat(event.Name)
+			if err != nil || watchIgnored(event.Name, info.IsDir()) {
+				continue
+			}
+			for _, source := range sources {
+				if err := w.addRecursive(source, event.Name, true); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+			}
+			if len(w.pending) > 0 {
+				timer.Reset(config.watchDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintln(os.Stderr, "watch error:", err)
+		case <-timer.C:
+			data = w.flush(data, config)
+			writeAddressbook(data, addressbook, config)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaildirKey(t *testing.T) {
+	tests := []struct {
+		testname string
+		in       string
+		want     string
+	}{
+		{"new", "new/1736000000.M1P2.host", "1736000000.M1P2.host"},
+		{"cur with flags", "cur/1736000000.M1P2.host:2,S", "1736000000.M1P2.host"},
+		{"mbsync uid", "cur/1736000000.M1P2.host,U=12:2,RS", "1736000000.M1P2.host"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			assert.Equal(t, tt.want, maildirKey(tt.in))
+		})
+	}
+}
+
+func TestWatcherQueue(t *testing.T) {
+	maildir := copyTestdata(t, "./testdata/endtoend")
+	watcher, err := fsnotify.NewWatcher()
+	assert.NoError(t, err)
+	defer watcher.Close()
+	w := &mailWatcher{
+		watcher: watcher,
+		sources: []mailSource{{Path: maildir}},
+		dirs:    make(map[string][]int),
+		known:   make(map[watchKey]bool),
+		pending: make(map[watchKey]string),
+	}
+	assert.NoError(t, w.addRecursive(0, maildir, false))
+	assert.Empty(t, w.pending)
+
+	os.MkdirAll(filepath.Join(maildir, "new", "cur"), os.ModePerm)
+	content, _ := os.ReadFile(filepath.Join(maildir, "from_me", "from_me_001.eml"))
+	os.WriteFile(filepath.Join(maildir, "new", "cur", "1736000000.M1P2.host:2,S"), content, 0o644)
+	assert.NoError(t, w.addRecursive(0, filepath.Join(maildir, "new"), true))
+	assert.Len(t, w.pending, 1)
+
+	w.queue(0, filepath.Join(maildir, "new", "cur", "1736000000.M1P2.host:2,RS"))
+	w.queue(0, filepath.Join(maildir, "from_me", "from_me_001.eml"))
+	assert.Len(t, w.pending, 1)
+
+	data := map[string]AddressData{}
+	data = w.flush(data, Config{})
+	assert.Empty(t, w.pending)
+	assert.Equal(t, 1, data["friend1@friends.com"].ClassCount[2])
+}
+
+func TestWatchIgnored(t *testing.T) {
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"mail/cur/1736000000.M1P2.host", false, false},
+		{"mail/tmp/1736000000.M1P2.host", false, true},
+		{"mail/.mbsyncstate", false, true},
+		{"mail/.Sent", true, false},
+		{"mail/.INBOX.Archive/cur", true, false},
+		{"mail/.notmuch", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, watchIgnored(tt.path, tt.isDir))
+		})
+	}
+}
+
+func TestWatcherHiddenFolders(t *testing.T) {
+	maildir := t.TempDir()
+	os.MkdirAll(filepath.Join(maildir, ".Sent", "cur"), os.ModePerm)
+	os.MkdirAll(filepath.Join(maildir, ".notmuch", "xapian"), os.ModePerm)
+	watcher, err := fsnotify.NewWatcher()
+	assert.NoError(t, err)
+	defer watcher.Close()
+	w := &mailWatcher{
+		watcher: watcher,
+		sources: []mailSource{{Path: maildir}},
+		dirs:    make(map[string][]int),
+		known:   make(map[watchKey]bool),
+		pending: make(map[watchKey]string),
+	}
+	assert.NoError(t, w.addRecursive(0, maildir, false))
+	assert.Contains(t, w.dirs, filepath.Join(maildir, ".Sent", "cur"))
+	assert.NotContains(t, w.dirs, filepath.Join(maildir, ".notmuch"))
+
+	content, _ := os.ReadFile("./testdata/endtoend/from_me/from_me_001.eml")
+	os.MkdirAll(filepath.Join(maildir, ".Sub", "cur"), os.ModePerm)
+	os.WriteFile(filepath.Join(maildir, ".Sub", "cur", "1736000000.M1P2.host:2,S"), content, 0o644)
+	os.WriteFile(filepath.Join(maildir, ".Sub", ".uidvalidity"), []byte("1"), 0o644)
+	assert.NoError(t, w.addRecursive(0, filepath.Join(maildir, ".Sub"), true))
+	assert.Contains(t, w.dirs, filepath.Join(maildir, ".Sub", "cur"))
+	assert.Equal(t, map[watchKey]string{{0, "1736000000.M1P2.host"}: filepath.Join(maildir, ".Sub", "cur", "1736000000.M1P2.host:2,S")}, w.pending)
+}
+
+func TestWatcherOverlappingSources(t *testing.T) {
+	maildir := copyTestdata(t, "./testdata/endtoend")
+	watcher, err := fsnotify.NewWatcher()
+	assert.NoError(t, err)
+	defer watcher.Close()
+	w := &mailWatcher{
+		watcher: watcher,
+		sources: []mailSource{
+			{Path: maildir},
+			{Path: filepath.Join(maildir, "from_me"), Sent: true, Weight: 1},
+		},
+		dirs:    make(map[string][]int),
+		known:   make(map[watchKey]bool),
+		pending: make(map[watchKey]string),
+	}
+	assert.NoError(t, w.addRecursive(0, maildir, false))
+	assert.NoError(t, w.addRecursive(1, filepath.Join(maildir, "from_me"), false))
+	assert.Equal(t, []int{0, 1}, w.dirs[filepath.Join(maildir, "from_me")])
+	assert.Equal(t, []int{0}, w.dirs[maildir])
+
+	content, _ := os.ReadFile(filepath.Join(maildir, "from_me", "from_me_001.eml"))
+	path := filepath.Join(maildir, "from_me", "1736000000.M1P2.host:2,S")
+	os.WriteFile(path, content, 0o644)
+	for _, source := range w.dirs[filepath.Dir(path)] {
+		w.queue(source, path)
+	}
+	assert.Len(t, w.pending, 2)
+
+	data := w.flush(map[string]AddressData{}, Config{})
+	assert.Equal(t, 2, data["friend1@friends.com"].ClassCount[2])
+}